@@ -4,8 +4,13 @@ go 1.16
 
 require (
 	github.com/form3tech-oss/jwt-go v3.2.2+incompatible
-	github.com/gofiber/fiber/v2 v2.9.0
+	github.com/gofiber/fiber/v2 v2.14.0
 	github.com/gofiber/jwt/v2 v2.2.1
 	github.com/joho/godotenv v1.3.0
 	go.mongodb.org/mongo-driver v1.5.2
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 )
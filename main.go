@@ -1,13 +1,26 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"container/list"
 	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"github.com/form3tech-oss/jwt-go"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/gofiber/fiber/v2/utils"
 	jwtMiddleware "github.com/gofiber/jwt/v2"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,12 +28,32 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 // load configuration
@@ -36,45 +69,661 @@ func IsProduction() bool {
 	return os.Getenv("APP_ENVIRONMENT") == "production"
 }
 
+// IsReadOnly for maintenance mode, reads are served and writes are rejected
+func IsReadOnly() bool {
+	return os.Getenv("MAINTENANCE_MODE") == "read-only"
+}
+
 // Travel for field represent in table
 type Travel struct {
-	ObjectID primitive.ObjectID `json:"id" bson:"_id"`
-	Name 	string 	`json:"name" 	bson:"name"`
-	Photo 	string 	`json:"photo" 	bson:"photo"`
-	Done 	bool 	`json:"done" 	bson:"done"`
+	ObjectID       primitive.ObjectID `json:"id" bson:"_id"`
+	Name           string             `json:"name" bson:"name"`
+	NameNormalized string             `json:"-" bson:"name_normalized"`
+	Slug           string             `json:"slug,omitempty" bson:"slug,omitempty"`
+	Description    string             `json:"description" bson:"description"`
+	Photo          string             `json:"photo" bson:"photo"`
+	Tags           []string           `json:"tags,omitempty" bson:"tags"`
+	Done           bool               `json:"done" bson:"done"`
+	Status         string             `json:"status" bson:"status"`
+	Position       int                `json:"position" bson:"position,omitempty"`
+	Archived       bool               `json:"archived" bson:"archived,omitempty"`
+	LockedBy       string             `json:"locked_by,omitempty" bson:"locked_by,omitempty"`
+	LockedAt       *time.Time         `json:"locked_at,omitempty" bson:"locked_at,omitempty"`
+	Views          int64              `json:"views" bson:"views,omitempty"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt      time.Time          `json:"updated_at" bson:"updated_at,omitempty"`
+
+	// omitPhoto leaves photo out of the JSON, for the lists which did not read it
+	omitPhoto bool
+}
+
+// displayLocation for the time zone of the timestamps of a Travel in JSON, DISPLAY_TZ.
+// Nil keeps them in UTC, as stored. It is set once at startup.
+var displayLocation *time.Location
+
+// loadDisplayLocation() for the time zone of DISPLAY_TZ, nil when it is empty
+func loadDisplayLocation() (*time.Location, error) {
+	name := os.Getenv("DISPLAY_TZ")
+	if name == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DISPLAY_TZ: %w", err)
+	}
+	return loc, nil
+}
+
+// travelJSON for a Travel without its MarshalJSON
+type travelJSON Travel
+
+// MarshalJSON() for a Travel with created_at and updated_at in displayLocation
+func (t Travel) MarshalJSON() ([]byte, error) {
+	if displayLocation != nil {
+		// a zero time is left alone, it marks a legacy travel
+		if !t.CreatedAt.IsZero() {
+			t.CreatedAt = t.CreatedAt.In(displayLocation)
+		}
+		if !t.UpdatedAt.IsZero() {
+			t.UpdatedAt = t.UpdatedAt.In(displayLocation)
+		}
+	}
+	if t.omitPhoto {
+		return json.Marshal(struct {
+			travelJSON
+			Photo *string `json:"photo,omitempty"`
+		}{travelJSON: travelJSON(t)})
+	}
+	return json.Marshal(travelJSON(t))
+}
+
+// maxDescriptionLength for the longest description accepted, in characters
+const maxDescriptionLength = 2000
+
+// defaultMaxNameLength for the longest name accepted without MAX_NAME_LENGTH, in characters
+const defaultMaxNameLength = 200
+
+// maxNameLength() for the longest name accepted, MAX_NAME_LENGTH or defaultMaxNameLength.
+// The collection validator enforces it too when SCHEMA_ENFORCEMENT is on.
+func maxNameLength() int {
+	if n, err := strconv.Atoi(os.Getenv("MAX_NAME_LENGTH")); err == nil && n > 0 {
+		return n
+	}
+	return defaultMaxNameLength
+}
+
+// maxTagLength for the longest tag accepted, in characters
+const maxTagLength = 50
+
+// maxTagsPerTravel() for the most tags a travel may have, MAX_TAGS_PER_TRAVEL or 20
+func maxTagsPerTravel() int {
+	if n, err := strconv.Atoi(os.Getenv("MAX_TAGS_PER_TRAVEL")); err == nil && n > 0 {
+		return n
+	}
+	return 20
 }
 
+// errTravelNotFound for a missing travel
+var errTravelNotFound = errors.New("travel not found")
+
+// errReorderMismatch for a reorder which does not list every travel exactly once
+var errReorderMismatch = errors.New("ids must list every travel exactly once")
+
+// errPreconditionFailed for a conditional write on a travel which changed since the given version
+var errPreconditionFailed = errors.New("travel was modified, fetch it again to get the current ETag")
+
+// errTravelConflict for a travel id which is already taken
+var errTravelConflict = errors.New("travel with this id already exists")
+
+// errTravelLocked for a travel locked for editing by another user
+var errTravelLocked = errors.New("travel is locked for editing by another user")
+
+// errDocumentInvalid for a write the collection validator rejected
+var errDocumentInvalid = errors.New("travel was rejected by the collection validator")
+
+// errAnonymousLock for a lock requested with a token which does not say who its user is
+var errAnonymousLock = errors.New("locking needs a token with a sub claim identifying its user")
+
 // Travels for Travel slices
 type Travels = []Travel
 
+// TagCount for the number of travels carrying a tag
+type TagCount struct {
+	Tag   string `json:"tag" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// PopularCount for how many travels share a name or a tag
+type PopularCount struct {
+	Value string `json:"value" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// PeriodCount for the number of travels done in a period, which starts at Period
+type PeriodCount struct {
+	Period time.Time `json:"period" bson:"_id"`
+	Count  int       `json:"count" bson:"count"`
+}
+
+// MonthCount for the number of travels created in a month, such as 2021-05
+type MonthCount struct {
+	Month string `json:"month" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// Streak for the consecutive days, up to today, with at least one travel done
+type Streak struct {
+	// Current counts the days ending today, or yesterday when nothing is done yet today
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+	// Days counts the days with at least one travel done
+	Days     int    `json:"days"`
+	LastDone string `json:"last_done,omitempty"`
+}
+
+// ScoredTravel for a Travel with its text search relevance
+type ScoredTravel struct {
+	Travel `bson:",inline"`
+	Score  float64 `json:"score" bson:"score"`
+}
+
+// MarshalJSON() for the fields of the Travel and the score, the MarshalJSON of Travel would drop it
+func (t ScoredTravel) MarshalJSON() ([]byte, error) {
+	return marshalTravelWith(t.Travel, "score", t.Score)
+}
+
+// SimilarTravel for a Travel with how many tags it shares with another one
+type SimilarTravel struct {
+	Travel `bson:",inline"`
+	Shared int `json:"shared" bson:"shared"`
+}
+
+// MarshalJSON() for the fields of the Travel and the shared count
+func (t SimilarTravel) MarshalJSON() ([]byte, error) {
+	return marshalTravelWith(t.Travel, "shared", t.Shared)
+}
+
+// marshalTravelWith() for the JSON of a travel with one more field, for the types embedding Travel
+func marshalTravelWith(t Travel, field string, value interface{}) ([]byte, error) {
+	travel, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := json.Marshal(map[string]interface{}{field: value})
+	if err != nil {
+		return nil, err
+	}
+	// both are objects, the field goes before the closing brace of the travel
+	travel = append(travel[:len(travel)-1], ',')
+	return append(travel, extra[1:]...), nil
+}
+
+// TravelEvent for a change of a travel, pushed to event stream clients
+type TravelEvent struct {
+	Operation string  `json:"operation"`
+	ID        string  `json:"id"`
+	Travel    *Travel `json:"travel,omitempty"`
+}
+
+// auditCollection for the append-only collection of audit records, next to the travel collection
+const auditCollection = "audit"
+
+// AuditRecord for a write to a travel, who did it and what it changed
+type AuditRecord struct {
+	ObjectID primitive.ObjectID `json:"id" bson:"_id"`
+	Actor    string             `json:"actor" bson:"actor"`
+	IP       string             `json:"ip" bson:"ip"`
+	Action   string             `json:"action" bson:"action"`
+	TravelID string             `json:"travel_id" bson:"travel_id"`
+	At       time.Time          `json:"at" bson:"at"`
+	Before   *AuditSummary      `json:"before,omitempty" bson:"before,omitempty"`
+	After    *AuditSummary      `json:"after,omitempty" bson:"after,omitempty"`
+	// Changes is derived from Before and After when the history of a travel is read
+	Changes []FieldChange `json:"changes,omitempty" bson:"-"`
+}
+
+// FieldChange for a field of a travel an audited write changed
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// changes() for the fields of the summary which differ between before and after,
+// a missing side, such as before a create, is nil
+func changes(before, after *AuditSummary) []FieldChange {
+	var from, to AuditSummary
+	if before != nil {
+		from = *before
+	}
+	if after != nil {
+		to = *after
+	}
+	fields := []struct {
+		name     string
+		from, to interface{}
+		same     bool
+	}{
+		{"name", from.Name, to.Name, from.Name == to.Name},
+		{"description", from.Description, to.Description, from.Description == to.Description},
+		{"photo", from.Photo, to.Photo, from.Photo == to.Photo},
+		{"tags", from.Tags, to.Tags, sameTags(from.Tags, to.Tags)},
+		{"done", from.Done, to.Done, from.Done == to.Done},
+	}
+	var changed []FieldChange
+	for _, field := range fields {
+		if before == nil || after == nil || !field.same {
+			changed = append(changed, FieldChange{Field: field.name, From: summaryValue(before, field.from), To: summaryValue(after, field.to)})
+		}
+	}
+	return changed
+}
+
+// sameTags() for whether two lists hold the same tags in the same order, nil and empty are the same
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// summaryValue() for value, or nil when there is no summary
+func summaryValue(summary *AuditSummary, value interface{}) interface{} {
+	if summary == nil {
+		return nil
+	}
+	return value
+}
+
+// AuditSummary for the fields of a travel an audit record keeps, the ones a write may change.
+// Records written before description, photo and tags were kept have them empty.
+type AuditSummary struct {
+	Name        string   `json:"name" bson:"name"`
+	Description string   `json:"description" bson:"description,omitempty"`
+	Photo       string   `json:"photo" bson:"photo,omitempty"`
+	Tags        []string `json:"tags" bson:"tags,omitempty"`
+	Done        bool     `json:"done" bson:"done"`
+}
+
+// summarize() for the audit summary of a travel, nil for no travel
+func summarize(travel *Travel) *AuditSummary {
+	if travel == nil {
+		return nil
+	}
+	return &AuditSummary{
+		Name:        travel.Name,
+		Description: travel.Description,
+		Photo:       travel.Photo,
+		Tags:        travel.Tags,
+		Done:        travel.Done,
+	}
+}
+
+// FieldError for a single violated rule of a field
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	// args fill the message of the rule in another locale, the field first
+	args []interface{}
+}
+
+// newFieldError() for a FieldError with the English message of its rule
+func newFieldError(field, rule string, params ...interface{}) FieldError {
+	args := append([]interface{}{field}, params...)
+	return FieldError{Field: field, Rule: rule, Message: message(defaultLocale, rule, args...), args: args}
+}
+
+// ValidationError for all violated rules of a payload
+type ValidationError []FieldError
+
+func (v ValidationError) Error() string {
+	messages := make([]string, len(v))
+	for i, fieldErr := range v {
+		messages[i] = fieldErr.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// normalizeName() for trim and collapse the whitespace of a name
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// statusOf() for the status of a travel, which follows done
+func statusOf(done bool) string {
+	if done {
+		return "done"
+	}
+	return "pending"
+}
+
+// normalize() for clean up the name and derive name_normalized for search and sorting, and the status.
+// name_normalized is not unique, two travels may share a name.
+func (t *Travel) normalize() {
+	t.Name = normalizeName(t.Name)
+	t.NameNormalized = strings.ToLower(t.Name)
+	t.Status = statusOf(t.Done)
+	t.Description = strings.TrimSpace(t.Description)
+	t.Tags = normalizeTags(t.Tags)
+}
+
+// slugify() for the URL slug of a name, its lowercase ASCII letters and digits joined by dashes
+func slugify(name string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if slug.Len() == 0 {
+		return "travel"
+	}
+	return slug.String()
+}
+
+// normalizeTags() for lowercase tags with their whitespace collapsed, empty and repeated tags are dropped
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		if tag = strings.ToLower(normalizeName(tag)); tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// Validate() for check a Travel before it is written
+func (t *Travel) Validate() error {
+	var errs ValidationError
+	if strings.TrimSpace(t.Name) == "" {
+		errs = append(errs, newFieldError("name", "required"))
+	} else if utf8.RuneCountInString(t.Name) > maxNameLength() {
+		errs = append(errs, newFieldError("name", "max", maxNameLength()))
+	}
+	if utf8.RuneCountInString(t.Description) > maxDescriptionLength {
+		errs = append(errs, newFieldError("description", "max", maxDescriptionLength))
+	}
+	if len(t.Tags) > maxTagsPerTravel() {
+		errs = append(errs, newFieldError("tags", "max_items", maxTagsPerTravel()))
+	}
+	for i, tag := range t.Tags {
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			errs = append(errs, newFieldError(fmt.Sprintf("tags[%d]", i), "max", maxTagLength))
+		}
+	}
+	if t.Photo != "" && !isHTTPURL(t.Photo) {
+		errs = append(errs, newFieldError("photo", "url"))
+	} else if t.Photo != "" && !allowedPhotoHost(t.Photo) {
+		errs = append(errs, newFieldError("photo", "host", strings.Join(envList("ALLOWED_PHOTO_HOSTS"), ", ")))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// FieldSchema for the shape and rules of a Travel field, for clients generating forms
+type FieldSchema struct {
+	Name        string                 `json:"name"`
+	Type        string                 `json:"type"`
+	Required    bool                   `json:"required"`
+	ReadOnly    bool                   `json:"readOnly"`
+	Constraints map[string]interface{} `json:"constraints,omitempty"`
+}
+
+// travelSchema() for the fields of a Travel, it must describe the rules of Validate()
+func travelSchema() []FieldSchema {
+	return []FieldSchema{
+		{Name: "id", Type: "string", ReadOnly: true, Constraints: map[string]interface{}{"format": "objectid"}},
+		{Name: "name", Type: "string", Required: true, Constraints: map[string]interface{}{
+			"trim":      true,
+			"maxLength": maxNameLength(),
+		}},
+		{Name: "slug", Type: "string", ReadOnly: true, Constraints: map[string]interface{}{"pattern": "^[a-z0-9]+(-[a-z0-9]+)*$"}},
+		{Name: "description", Type: "string", Constraints: map[string]interface{}{
+			"trim":      true,
+			"maxLength": maxDescriptionLength,
+		}},
+		{Name: "photo", Type: "string", Constraints: photoConstraints()},
+		{Name: "tags", Type: "array", Constraints: map[string]interface{}{
+			"items":     "string",
+			"lowercase": true,
+			"unique":    true,
+			"maxItems":  maxTagsPerTravel(),
+			"maxLength": maxTagLength,
+		}},
+		{Name: "done", Type: "boolean"},
+		{Name: "status", Type: "string", ReadOnly: true, Constraints: map[string]interface{}{"enum": []string{"pending", "done"}}},
+		{Name: "position", Type: "integer", ReadOnly: true},
+		{Name: "archived", Type: "boolean", ReadOnly: true},
+		{Name: "locked_by", Type: "string", ReadOnly: true},
+		{Name: "locked_at", Type: "string", ReadOnly: true, Constraints: map[string]interface{}{"format": "date-time"}},
+		{Name: "views", Type: "integer", ReadOnly: true},
+		{Name: "created_at", Type: "string", ReadOnly: true, Constraints: map[string]interface{}{"format": "date-time"}},
+		{Name: "updated_at", Type: "string", ReadOnly: true, Constraints: map[string]interface{}{"format": "date-time"}},
+	}
+}
+
+// photoConstraints() for the rules of the photo field, with the allowed hosts when ALLOWED_PHOTO_HOSTS is set
+func photoConstraints() map[string]interface{} {
+	constraints := map[string]interface{}{
+		"format":  "url",
+		"schemes": []string{"http", "https"},
+	}
+	if hosts := envList("ALLOWED_PHOTO_HOSTS"); len(hosts) > 0 {
+		constraints["hosts"] = hosts
+	}
+	return constraints
+}
+
+// isHTTPURL() for check a value is an absolute http(s) URL
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// allowedPhotoHost() for check a photo URL points to a host of ALLOWED_PHOTO_HOSTS, any host is allowed when it is empty
+func allowedPhotoHost(value string) bool {
+	hosts := envList("ALLOWED_PHOTO_HOSTS")
+	if len(hosts) == 0 {
+		return true
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	for _, host := range hosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// listOptions for how findAll shapes the listed travels
+type listOptions struct {
+	// withPhoto includes the photo field, which is left out of lists by default
+	withPhoto bool
+	// sort orders the travels, see parseSort()
+	sort bson.D
+	// limit and offset page the travels, a zero limit lists them all
+	limit  int64
+	offset int64
+}
+
+// travelPage for a page of travels with the number of travels matching the filter across all pages
+type travelPage struct {
+	Data  *Travels `json:"data"`
+	Total int64    `json:"total"`
+}
+
+// maxFacetLimit for the largest page withTotal reads, its page and count come in a single document
+const maxFacetLimit = 1000
+
+// maxPageOffset() for the largest offset a list may skip to, MAX_PAGE_OFFSET or 10000
+func maxPageOffset() int64 {
+	maxOffset, err := strconv.ParseInt(os.Getenv("MAX_PAGE_OFFSET"), 10, 64)
+	if err != nil || maxOffset <= 0 {
+		return 10000
+	}
+	return maxOffset
+}
+
+// parsePage() for the limit and offset of a list request, skipping further than maxPageOffset() is refused
+func parsePage(c *fiber.Ctx) (limit, offset int64, err error) {
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.ParseInt(value, 10, 64); err != nil || limit < 0 {
+			return 0, 0, errors.New("limit must be a non-negative integer")
+		}
+	}
+	if value := c.Query("offset"); value != "" {
+		if offset, err = strconv.ParseInt(value, 10, 64); err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+	}
+	if maxOffset := maxPageOffset(); offset > maxOffset {
+		return 0, 0, fmt.Errorf("offset must be at most %d, page deeper with a cursor instead", maxOffset)
+	}
+	return limit, offset, nil
+}
+
+// sortFields for the fields a list may be sorted by, by their JSON name
+var sortFields = map[string]string{
+	"id":         "_id",
+	"name":       "name_normalized",
+	"done":       "done",
+	"position":   "position",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// parseSort() for parse a sort such as "created_at:desc,name", the direction is asc by default.
+// The id is always the last key, so the order of equal values is deterministic.
+func parseSort(value string) (bson.D, error) {
+	var sort bson.D
+	hasID := false
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, direction := part, "asc"
+		if i := strings.Index(part, ":"); i >= 0 {
+			name, direction = part[:i], part[i+1:]
+		}
+		field, ok := sortFields[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %q", name)
+		}
+		switch direction {
+		case "asc":
+			sort = append(sort, bson.E{Key: field, Value: 1})
+		case "desc":
+			sort = append(sort, bson.E{Key: field, Value: -1})
+		default:
+			return nil, fmt.Errorf("sort direction of %q must be asc or desc", name)
+		}
+		hasID = hasID || field == "_id"
+	}
+	if !hasID {
+		sort = append(sort, bson.E{Key: "_id", Value: 1})
+	}
+	return sort, nil
+}
+
+// defaultSort() for the sort of a list without a sort query param, DEFAULT_SORT or the insertion order
+func defaultSort() (bson.D, error) {
+	sort, err := parseSort(os.Getenv("DEFAULT_SORT"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEFAULT_SORT: %w", err)
+	}
+	return sort, nil
+}
+
 // DBRepository for Travel repository
 type DBRepository struct {
-	client 		*mongo.Client
-	database	*mongo.Database
-	Collection 	*mongo.Collection
+	client     *mongo.Client
+	database   *mongo.Database
+	Collection *mongo.Collection
+	slowQuery  time.Duration
 }
 
 // Repository for Travel repository interfaces
 type Repository interface {
 	ping() (string, error)
-	findAll(ctx context.Context) (*Travels, error)
+	replicaLag(ctx context.Context) (time.Duration, error)
+	missingIndexes(ctx context.Context) ([]string, error)
+	findAll(ctx context.Context, filter bson.M, opts listOptions) (*Travels, error)
+	forEach(ctx context.Context, filter bson.M, fn func(*Travel) error) error
+	findPage(ctx context.Context, filter bson.M, opts listOptions) (*Travels, int64, error)
 	findOne(ctx context.Context, id string) (*Travel, error)
+	findBySlug(ctx context.Context, slug string) (*Travel, error)
+	exists(ctx context.Context, id string) (bool, error)
+	findRandom(ctx context.Context, filter bson.M) (*Travel, error)
+	findNext(ctx context.Context) (*Travel, error)
+	findRecent(ctx context.Context, limit int64) (*Travels, error)
+	textSearch(ctx context.Context, filter bson.M, limit, offset int64) (*[]ScoredTravel, error)
+	countTags(ctx context.Context, filter bson.M) (*[]TagCount, error)
+	countByMonth(ctx context.Context, filter bson.M) (*[]MonthCount, error)
+	popular(ctx context.Context, by string, limit int64) (*[]PopularCount, error)
+	similar(ctx context.Context, travel *Travel, limit int64) (*[]SimilarTravel, error)
+	completionTimeline(ctx context.Context, bucket string, loc *time.Location) (*[]PeriodCount, error)
+	doneTimes(ctx context.Context) ([]time.Time, error)
+	distinctPhotos(ctx context.Context) ([]string, error)
+	count(ctx context.Context, filter bson.M) (int64, error)
 	insertOne(ctx context.Context, travel *Travel) error
-	updateOne(ctx context.Context, id string, travel *Travel) error
+	insertMany(ctx context.Context, travels []Travel) (int, error)
+	updateOne(ctx context.Context, id string, travel *Travel, version *time.Time) error
+	updateOneAndReturn(ctx context.Context, id string, travel *Travel, version *time.Time) (*Travel, error)
 	updateField(ctx context.Context, id, field string, value interface{}) error
-	deleteOne(ctx context.Context, id string) error
+	incrementViews(ctx context.Context, id string) (int64, error)
+	lock(ctx context.Context, id, actor string, ttl time.Duration) (time.Time, error)
+	unlock(ctx context.Context, id, actor string, ttl time.Duration) error
+	patchOne(ctx context.Context, id string, set bson.M, unset []string, version *time.Time) error
+	updateMany(ctx context.Context, filter bson.M, set bson.M) (int64, error)
+	assignTags(ctx context.Context, ids []primitive.ObjectID, tags []string, mode string) (int64, error)
+	merge(ctx context.Context, id, sourceID string) (before, merged *Travel, err error)
+	renameMany(ctx context.Context, find, replace string) (int64, error)
+	backfill(ctx context.Context, field string, value interface{}) (int64, error)
+	backfillEach(ctx context.Context, field string, derive func(*Travel) bson.M) (int64, error)
+	deleteOne(ctx context.Context, id string, since *time.Time) (*Travel, error)
+	reorder(ctx context.Context, ids []string) error
+	watch(ctx context.Context) (<-chan TravelEvent, error)
+	insertAudit(ctx context.Context, record *AuditRecord) error
+	findAudit(ctx context.Context, filter bson.M, limit, offset int64) (*[]AuditRecord, int64, error)
 	Close()
 }
 
-// NewRepo for Travel Repository initialize
+// NewRepo for Travel Repository initialize, uri may be a mongodb+srv:// seed list
 func NewRepo(uri string) (Repository, error) {
-	client, err := mongo.NewClient(options.Client().ApplyURI(uri))
+	clientOptions, err := dbClientOptions(uri)
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.NewClient(clientOptions)
 	log.Println("db client created")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 	err = client.Connect(ctx)
 
@@ -89,300 +738,4803 @@ func NewRepo(uri string) (Repository, error) {
 	}
 	log.Println("db client ping")
 
+	if os.Getenv("DB_POOL_WARMUP") == "true" {
+		size := defaultWarmupSize
+		if clientOptions.MinPoolSize != nil && *clientOptions.MinPoolSize > 0 {
+			size = int(*clientOptions.MinPoolSize)
+		}
+		start := time.Now()
+		err := warmUp(size, func() error {
+			return client.Ping(ctx, readpref.Primary())
+		})
+		if err != nil {
+			log.Printf("warning: db pool warm-up failed: %v", err)
+		} else {
+			log.Printf("db pool warmed up with %d connections in %s", size, time.Since(start))
+		}
+	}
+
 	dbName := os.Getenv("DATABASE_NAME")
+	colName := os.Getenv("TRAVEL_COLLECTION")
+	if err := validateCollectionName(dbName, colName); err != nil {
+		return nil, err
+	}
 	db := client.Database(dbName)
-	col := db.Collection(os.Getenv("TRAVEL_COLLECTION"))
-	return &DBRepository{
-		client: 	client,
+	col := db.Collection(colName)
+
+	// checked before the indexes are ensured, which creates the collection
+	if os.Getenv("REQUIRE_EXISTING_COLLECTION") == "true" {
+		err := checkCollectionExists(dbName, colName, func(filter bson.M) ([]string, error) {
+			return db.ListCollectionNames(ctx, filter)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	slowQueryMillis, _ := strconv.Atoi(os.Getenv("SLOW_QUERY_THRESHOLD_MS"))
+	repo := &DBRepository{
+		client:     client,
 		database:   db,
 		Collection: col,
-	}, nil
-}
-
-// ping() for check connection is established?
-func (d *DBRepository) ping() (string, error) {
-	ctx := context.Background()
-	err := d.client.Ping(ctx, readpref.Primary())
-	if err != nil {
-		return "", errors.New("connection error")
+		slowQuery:  time.Millisecond * time.Duration(slowQueryMillis),
 	}
-	return "connection to database established", nil
-}
 
-// findAll() for find all travels
-func (d *DBRepository) findAll(ctx context.Context) (*Travels, error) {
-	c, err := d.Collection.Find(ctx, bson.D{})
-	if err != nil {
+	if err := repo.ensureIndexes(ctx); err != nil {
 		return nil, err
 	}
-	var travels Travels
-
-	for c.Next(ctx) {
-		var travel Travel
-		if err := c.Decode(&travel); err != nil {
+	for _, tenant := range tenants() {
+		if err := repo.ensureIndexes(withTenant(ctx, tenant)); err != nil {
 			return nil, err
 		}
-		travels = append(travels, travel)
 	}
-	if err := c.Close(ctx); err != nil {
-		return nil, err
+	log.Println("db indexes ensured")
+
+	if os.Getenv("SCHEMA_ENFORCEMENT") == "true" {
+		if err := repo.ensureValidator(ctx); err != nil {
+			return nil, err
+		}
+		for _, tenant := range tenants() {
+			if err := repo.ensureValidator(withTenant(ctx, tenant)); err != nil {
+				return nil, err
+			}
+		}
+		log.Println("db schema validator ensured")
 	}
-	return &travels, nil
+	return repo, nil
 }
 
-// findOne() for find a travel
-func (d *DBRepository) findOne(ctx context.Context, id string) (*Travel, error) {
-	objectId, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return nil, err
+// travelValidator() for the $jsonSchema of the collection, it must agree with Validate()
+func travelValidator() bson.M {
+	return bson.M{"$jsonSchema": bson.M{
+		"bsonType": "object",
+		"properties": bson.M{
+			"name": bson.M{"bsonType": "string", "maxLength": maxNameLength()},
+		},
+	}}
+}
+
+// ensureValidator() for set travelValidator() on the collection, which ensureIndexes creates.
+// Moderate validation leaves the updates of documents which are already invalid alone.
+func (d *DBRepository) ensureValidator(ctx context.Context) error {
+	col := d.collection(ctx)
+	return col.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: col.Name()},
+		{Key: "validator", Value: travelValidator()},
+		{Key: "validationLevel", Value: "moderate"},
+	}).Err()
+}
+
+// documentValidationFailure for the server error of a write rejected by the collection validator
+const documentValidationFailure = 121
+
+// isDocumentInvalid() for check a write was rejected by the collection validator
+func isDocumentInvalid(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(documentValidationFailure)
+}
+
+// defaultWarmupSize for the connections DB_POOL_WARMUP opens when the URI sets no minPoolSize
+const defaultWarmupSize = 5
+
+// warmUp() for open size connections of the pool ahead of the first requests, with as many pings at once.
+// Each concurrent ping checks out a connection of its own.
+func warmUp(size int, ping func() error) error {
+	errs := make(chan error, size)
+	for i := 0; i < size; i++ {
+		go func() {
+			errs <- ping()
+		}()
 	}
-	res := d.Collection.FindOne(ctx, bson.M{"_id": objectId})
-	var travel Travel
-	if err := res.Decode(&travel); err != nil {
-		return nil, err
+	var err error
+	for i := 0; i < size; i++ {
+		if pingErr := <-errs; pingErr != nil && err == nil {
+			err = pingErr
+		}
 	}
-	return &travel, nil
+	return err
 }
 
-// insertOne() for insert a data to collection
-func (d *DBRepository) insertOne(ctx context.Context, travel *Travel) error {
-	travel.ObjectID = primitive.NewObjectID()
-	if _, err := d.Collection.InsertOne(ctx, travel); err != nil {
-		return err
+// dbClientOptions() for the client options of uri, with the TLS settings of DB_TLS_CA_FILE and DB_TLS_INSECURE.
+// A mongodb+srv:// uri turns TLS on by itself.
+func dbClientOptions(uri string) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(uri)
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	caFile := os.Getenv("DB_TLS_CA_FILE")
+	insecure := os.Getenv("DB_TLS_INSECURE") == "true"
+	if caFile == "" && !insecure {
+		return opts, nil
 	}
-	return nil
-}
 
-// updateOne() for update a data in collection
-func (d *DBRepository) updateOne(ctx context.Context, id string, travel *Travel) error {
-	travel.ObjectID, _ = primitive.ObjectIDFromHex(id)
-	filter := bson.M{"_id": travel.ObjectID}
-	if _, err := d.Collection.ReplaceOne(ctx, filter, travel); err != nil {
-		return err
+	config := &tls.Config{}
+	if opts.TLSConfig != nil {
+		config = opts.TLSConfig.Clone()
 	}
-	return nil
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DB_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid DB_TLS_CA_FILE %q: no PEM certificate found", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if insecure {
+		if IsProduction() {
+			return nil, errors.New("invalid DB_TLS_INSECURE: certificate verification cannot be skipped in production")
+		}
+		log.Println("warning: DB_TLS_INSECURE is set, the database certificate is not verified")
+		config.InsecureSkipVerify = true
+	}
+	return opts.SetTLSConfig(config), nil
 }
 
-// updateField() for update a field
-func (d *DBRepository) updateField(ctx context.Context, id, field string, value interface{}) error {
-	objectID, _ := primitive.ObjectIDFromHex(id)
-	filter := bson.M{"_id": objectID}
-	update := bson.D{{
-		"$set", bson.D{{
-			field, value,
-		}},
-	}}
-	if _, err := d.Collection.ReplaceOne(ctx, filter, update); err != nil {
-		return err
+// validateCollectionName() for check TRAVEL_COLLECTION against the MongoDB naming rules
+func validateCollectionName(dbName, name string) error {
+	switch {
+	case name == "":
+		return errors.New("invalid TRAVEL_COLLECTION: must not be empty")
+	case strings.ContainsAny(name, "$\x00"):
+		return fmt.Errorf("invalid TRAVEL_COLLECTION %q: must not contain '$' or a null character", name)
+	case strings.HasPrefix(name, "system."):
+		return fmt.Errorf("invalid TRAVEL_COLLECTION %q: the system. prefix is reserved", name)
+	case len(dbName)+1+len(name) > 255:
+		return fmt.Errorf("invalid TRAVEL_COLLECTION %q: the namespace must be at most 255 bytes", name)
 	}
 	return nil
 }
 
-// deleteOne() for delete a data from coll
-func (d *DBRepository) deleteOne(ctx context.Context, id string) error {
-	objectId, err := primitive.ObjectIDFromHex(id)
+// checkCollectionExists() for warn when TRAVEL_COLLECTION is not in the database yet, listNames lists the
+// names of the collections matching a filter
+func checkCollectionExists(dbName, name string, listNames func(filter bson.M) ([]string, error)) error {
+	names, err := listNames(bson.M{"name": name})
 	if err != nil {
 		return err
 	}
-	if _, err := d.Collection.DeleteOne(ctx, bson.M{"_id": objectId}); err != nil {
-		return err
+	if len(names) == 0 {
+		log.Printf("warning: collection %q does not exist in database %q, check TRAVEL_COLLECTION", name, dbName)
 	}
 	return nil
 }
 
-// Close Close() for close connection
-func (d *DBRepository) Close() {
-	if err := d.client.Disconnect(context.Background()); err != nil {
+// collection() for the travel collection of the tenant in ctx, the default database is used without one
+func (d *DBRepository) collection(ctx context.Context) *mongo.Collection {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	if tenant == "" {
+		return d.Collection
+	}
+	return d.client.Database(d.database.Name() + "_" + tenant).Collection(d.Collection.Name())
+}
+
+// travelIndexes for the indexes the queries rely on, each one is named so the readiness probe can find it
+var travelIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+		Options: options.Index().SetName("travel_text"),
+	},
+	{
+		// partial, travels written before slugs existed have none
+		Keys: bson.D{{Key: "slug", Value: 1}},
+		Options: options.Index().SetName("travel_slug").SetUnique(true).
+			SetPartialFilterExpression(bson.M{"slug": bson.M{"$type": "string"}}),
+	},
+	{
+		// not unique, names may repeat and existing collections already hold duplicates
+		Keys:    bson.D{{Key: "name_normalized", Value: 1}},
+		Options: options.Index().SetName("travel_name"),
+	},
+}
+
+// ensureIndexes() for create the indexes the queries rely on
+func (d *DBRepository) ensureIndexes(ctx context.Context) error {
+	if _, err := d.collection(ctx).Indexes().CreateMany(ctx, travelIndexes); err != nil {
+		return err
+	}
+	_, err := d.collection(ctx).Database().Collection(auditCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "actor", Value: 1}, {Key: "at", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("audit_actor_at"),
+		},
+		{
+			Keys:    bson.D{{Key: "travel_id", Value: 1}, {Key: "at", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("audit_travel_at"),
+		},
+	})
+	return err
+}
+
+// missingIndexes() for the names of the travelIndexes which do not exist on the collection
+func (d *DBRepository) missingIndexes(ctx context.Context) ([]string, error) {
+	c, err := d.collection(ctx).Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []struct {
+		Name string `bson:"name"`
+	}
+	if err := c.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+	existing := map[string]bool{}
+	for _, index := range indexes {
+		existing[index.Name] = true
+	}
+	var missing []string
+	for _, index := range travelIndexes {
+		if name := *index.Options.Name; !existing[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// requestIDKey for the request id in a request context
+type requestIDKey struct{}
+
+// findOptions() for find options commented with the request id, to trace queries in the profiler
+func findOptions(ctx context.Context) *options.FindOptions {
+	opts := options.Find()
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok && id != "" {
+		opts.SetComment(id)
+	}
+	return opts
+}
+
+// findOneOptions() for find one options commented with the request id
+func findOneOptions(ctx context.Context) *options.FindOneOptions {
+	opts := options.FindOne()
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok && id != "" {
+		opts.SetComment(id)
+	}
+	return opts
+}
+
+// aggregateOptions() for aggregate options commented with the request id
+func aggregateOptions(ctx context.Context) *options.AggregateOptions {
+	opts := options.Aggregate()
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok && id != "" {
+		opts.SetComment(id)
+	}
+	return opts
+}
+
+// observe() for trace an operation as a span of the request of ctx, and log it when slower than
+// SLOW_QUERY_THRESHOLD_MS, deferred at the start of it
+func (d *DBRepository) observe(ctx context.Context, operation string, filter interface{}, start time.Time) {
+	end := time.Now()
+	// a child of the request span of ctx, started afterwards with the start of the operation
+	_, span := otel.Tracer(tracerName).Start(ctx, "repository."+operation,
+		trace.WithTimestamp(start),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMongoDB, semconv.DBOperationKey.String(operation)))
+	span.End(trace.WithTimestamp(end))
+
+	if d.slowQuery <= 0 {
+		return
+	}
+	if elapsed := end.Sub(start); elapsed > d.slowQuery {
+		log.Printf("level=warn msg=\"slow query\" operation=%s duration=%s filter=%v",
+			operation, elapsed, redactFilter(filter))
+	}
+}
+
+// redactFilter() for a filter with its keys kept and its values masked, safe to log
+func redactFilter(filter interface{}) interface{} {
+	switch f := filter.(type) {
+	case nil:
+		return "-"
+	case bson.M:
+		redacted := bson.M{}
+		for key, value := range f {
+			redacted[key] = redactFilter(value)
+		}
+		return redacted
+	case bson.D:
+		redacted := bson.M{}
+		for _, e := range f {
+			redacted[e.Key] = redactFilter(e.Value)
+		}
+		return redacted
+	case bson.A:
+		redacted := make(bson.A, len(f))
+		for i, value := range f {
+			redacted[i] = redactFilter(value)
+		}
+		return redacted
+	default:
+		return "?"
+	}
+}
+
+// pingTimeout for how long ping() waits for the primary
+const pingTimeout = 5 * time.Second
+
+// ping() for check connection is established?
+func (d *DBRepository) ping() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	err := d.client.Ping(ctx, readpref.Primary())
+	if err != nil {
+		return "", fmt.Errorf("connection error: %w", err)
+	}
+	return "connection to database established", nil
+}
+
+// replicaLag() for how far the most lagging secondary is behind the primary, from replSetGetStatus.
+// It is zero without a primary or secondaries.
+func (d *DBRepository) replicaLag(ctx context.Context) (time.Duration, error) {
+	var status struct {
+		Members []struct {
+			StateStr   string    `bson:"stateStr"`
+			OptimeDate time.Time `bson:"optimeDate"`
+		} `bson:"members"`
+	}
+	cmd := bson.D{{Key: "replSetGetStatus", Value: 1}}
+	if err := d.client.Database("admin").RunCommand(ctx, cmd).Decode(&status); err != nil {
+		return 0, err
+	}
+	var primary, oldest time.Time
+	for _, member := range status.Members {
+		switch member.StateStr {
+		case "PRIMARY":
+			primary = member.OptimeDate
+		case "SECONDARY":
+			if oldest.IsZero() || member.OptimeDate.Before(oldest) {
+				oldest = member.OptimeDate
+			}
+		}
+	}
+	if primary.IsZero() || oldest.IsZero() || !oldest.Before(primary) {
+		return 0, nil
+	}
+	return primary.Sub(oldest), nil
+}
+
+// findAll() for find all travels matching the filter
+func (d *DBRepository) findAll(ctx context.Context, filter bson.M, opts listOptions) (*Travels, error) {
+	defer d.observe(ctx, "findAll", filter, time.Now())
+	findOpts := findOptions(ctx).SetSort(opts.sort)
+	if !opts.withPhoto {
+		findOpts.SetProjection(bson.M{"photo": 0})
+	}
+	if opts.offset > 0 {
+		findOpts.SetSkip(opts.offset)
+	}
+	if opts.limit > 0 {
+		findOpts.SetLimit(opts.limit)
+	}
+	c, err := d.collection(ctx).Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	// not nil, so an empty list is encoded as [] rather than null
+	travels := Travels{}
+
+	for c.Next(ctx) {
+		var travel Travel
+		if err := c.Decode(&travel); err != nil {
+			return nil, err
+		}
+		travel.omitPhoto = !opts.withPhoto
+		travels = append(travels, travel)
+	}
+	if err := c.Close(ctx); err != nil {
+		return nil, err
+	}
+	return &travels, nil
+}
+
+// findPage() for find a page of the travels matching the filter and how many match in total,
+// in a single $facet aggregation
+func (d *DBRepository) findPage(ctx context.Context, filter bson.M, opts listOptions) (*Travels, int64, error) {
+	defer d.observe(ctx, "findPage", filter, time.Now())
+	page := bson.A{bson.M{"$sort": opts.sort}}
+	if opts.offset > 0 {
+		page = append(page, bson.M{"$skip": opts.offset})
+	}
+	if opts.limit > 0 {
+		page = append(page, bson.M{"$limit": opts.limit})
+	}
+	if !opts.withPhoto {
+		page = append(page, bson.M{"$project": bson.M{"photo": 0}})
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: bson.M{
+			"data":  page,
+			"total": bson.A{bson.M{"$count": "count"}},
+		}}},
+	}
+	c, err := d.collection(ctx).Aggregate(ctx, pipeline, aggregateOptions(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer c.Close(ctx)
+
+	var result struct {
+		Data  Travels `bson:"data"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+	}
+	if c.Next(ctx) {
+		if err := c.Decode(&result); err != nil {
+			return nil, 0, err
+		}
+	} else if err := c.Err(); err != nil {
+		return nil, 0, err
+	}
+	travels := Travels{}
+	for _, travel := range result.Data {
+		travel.omitPhoto = !opts.withPhoto
+		travels = append(travels, travel)
+	}
+	var total int64
+	// $count outputs nothing when nothing matches
+	if len(result.Total) > 0 {
+		total = result.Total[0].Count
+	}
+	return &travels, total, nil
+}
+
+// forEach() for call fn with every travel matching the filter in insertion order, one at a time,
+// so memory stays bounded however many there are. An error of fn stops it and is returned.
+func (d *DBRepository) forEach(ctx context.Context, filter bson.M, fn func(*Travel) error) error {
+	defer d.observe(ctx, "forEach", filter, time.Now())
+	c, err := d.collection(ctx).Find(ctx, filter, findOptions(ctx).SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer c.Close(ctx)
+
+	for c.Next(ctx) {
+		var travel Travel
+		if err := c.Decode(&travel); err != nil {
+			return err
+		}
+		if err := fn(&travel); err != nil {
+			return err
+		}
+	}
+	return c.Err()
+}
+
+// findOne() for find a travel
+func (d *DBRepository) findOne(ctx context.Context, id string) (*Travel, error) {
+	defer d.observe(ctx, "findOne", bson.M{"_id": id}, time.Now())
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	res := d.collection(ctx).FindOne(ctx, bson.M{"_id": objectId}, findOneOptions(ctx))
+	var travel Travel
+	if err := res.Decode(&travel); err != nil {
+		return nil, err
+	}
+	return &travel, nil
+}
+
+// findBySlug() for find a travel by its slug, archived travels have none
+func (d *DBRepository) findBySlug(ctx context.Context, slug string) (*Travel, error) {
+	filter := bson.M{"slug": slug, "archived": bson.M{"$ne": true}}
+	defer d.observe(ctx, "findBySlug", filter, time.Now())
+	var travel Travel
+	if err := d.collection(ctx).FindOne(ctx, filter, findOneOptions(ctx)).Decode(&travel); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errTravelNotFound
+		}
+		return nil, err
+	}
+	return &travel, nil
+}
+
+// exists() for check a travel is exists without fetching the document
+func (d *DBRepository) exists(ctx context.Context, id string) (bool, error) {
+	defer d.observe(ctx, "exists", bson.M{"_id": id}, time.Now())
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+	count, err := d.collection(ctx).CountDocuments(ctx, bson.M{"_id": objectId}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// findRandom() for find a random travel matching the filter
+func (d *DBRepository) findRandom(ctx context.Context, filter bson.M) (*Travel, error) {
+	defer d.observe(ctx, "findRandom", filter, time.Now())
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sample", Value: bson.M{"size": 1}}},
+	}
+	c, err := d.collection(ctx).Aggregate(ctx, pipeline, aggregateOptions(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close(ctx)
+
+	if !c.Next(ctx) {
+		if err := c.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errTravelNotFound
+	}
+	var travel Travel
+	if err := c.Decode(&travel); err != nil {
+		return nil, err
+	}
+	return &travel, nil
+}
+
+// findNext() for find the oldest pending travel which is not archived
+func (d *DBRepository) findNext(ctx context.Context) (*Travel, error) {
+	filter := bson.M{"done": false, "archived": bson.M{"$ne": true}}
+	defer d.observe(ctx, "findNext", filter, time.Now())
+	opts := findOneOptions(ctx).SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	res := d.collection(ctx).FindOne(ctx, filter, opts)
+	var travel Travel
+	if err := res.Decode(&travel); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errTravelNotFound
+		}
+		return nil, err
+	}
+	return &travel, nil
+}
+
+// findRecent() for find the most recently created travels which are not archived
+func (d *DBRepository) findRecent(ctx context.Context, limit int64) (*Travels, error) {
+	filter := bson.M{"archived": bson.M{"$ne": true}}
+	defer d.observe(ctx, "findRecent", filter, time.Now())
+	opts := findOptions(ctx).
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(limit)
+	c, err := d.collection(ctx).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	travels := Travels{}
+
+	for c.Next(ctx) {
+		var travel Travel
+		if err := c.Decode(&travel); err != nil {
+			return nil, err
+		}
+		travels = append(travels, travel)
+	}
+	if err := c.Close(ctx); err != nil {
+		return nil, err
+	}
+	return &travels, nil
+}
+
+// textSearch() for find a page of the travels matching a filter with a $text query, most relevant first
+func (d *DBRepository) textSearch(ctx context.Context, filter bson.M, limit, offset int64) (*[]ScoredTravel, error) {
+	defer d.observe(ctx, "textSearch", filter, time.Now())
+	score := bson.M{"score": bson.M{"$meta": "textScore"}}
+	opts := findOptions(ctx).SetProjection(score).SetSort(score).SetSkip(offset).SetLimit(limit)
+	c, err := d.collection(ctx).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	travels := []ScoredTravel{}
+
+	for c.Next(ctx) {
+		var travel ScoredTravel
+		if err := c.Decode(&travel); err != nil {
+			return nil, err
+		}
+		travels = append(travels, travel)
+	}
+	if err := c.Close(ctx); err != nil {
+		return nil, err
+	}
+	return &travels, nil
+}
+
+// countTags() for count the travels matching the filter per tag, most used first.
+// A tag repeated in a travel is counted once, travels without tags are left out.
+func (d *DBRepository) countTags(ctx context.Context, filter bson.M) (*[]TagCount, error) {
+	defer d.observe(ctx, "countTags", filter, time.Now())
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$project", Value: bson.M{"tags": bson.M{"$setUnion": bson.A{"$tags", bson.A{}}}}}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	c, err := d.collection(ctx).Aggregate(ctx, pipeline, aggregateOptions(ctx))
+	if err != nil {
+		return nil, err
+	}
+	counts := []TagCount{}
+	if err := c.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+// popular() for the limit most common tags, or names when by is "name", of the travels which are not
+// archived, most common first. Names are compared normalized and reported as the first travel spells it.
+func (d *DBRepository) popular(ctx context.Context, by string, limit int64) (*[]PopularCount, error) {
+	filter := bson.M{"archived": bson.M{"$ne": true}}
+	defer d.observe(ctx, "popular", filter, time.Now())
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: filter}}}
+	if by == "name" {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+			bson.D{{Key: "$group", Value: bson.M{
+				// travels written before name_normalized existed are normalized here
+				"_id":   bson.M{"$ifNull": bson.A{"$name_normalized", bson.M{"$toLower": "$name"}}},
+				"name":  bson.M{"$first": "$name"},
+				"count": bson.M{"$sum": 1},
+			}}},
+			bson.D{{Key: "$project", Value: bson.M{"_id": "$name", "count": 1}}},
+		)
+	} else {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$project", Value: bson.M{"tags": bson.M{"$setUnion": bson.A{"$tags", bson.A{}}}}}},
+			bson.D{{Key: "$unwind", Value: "$tags"}},
+			bson.D{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		)
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: limit}},
+	)
+	c, err := d.collection(ctx).Aggregate(ctx, pipeline, aggregateOptions(ctx))
+	if err != nil {
+		return nil, err
+	}
+	counts := []PopularCount{}
+	if err := c.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+// similar() for the limit travels which share the most tags with the travel, not archived and
+// without the travel itself, the most shared tags first
+func (d *DBRepository) similar(ctx context.Context, travel *Travel, limit int64) (*[]SimilarTravel, error) {
+	similar := []SimilarTravel{}
+	if len(travel.Tags) == 0 {
+		return &similar, nil
+	}
+	filter := bson.M{
+		"_id":      bson.M{"$ne": travel.ObjectID},
+		"archived": bson.M{"$ne": true},
+		"tags":     bson.M{"$in": travel.Tags},
+	}
+	defer d.observe(ctx, "similar", filter, time.Now())
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{
+			"shared": bson.M{"$size": bson.M{"$setIntersection": bson.A{"$tags", travel.Tags}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "shared", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$project", Value: bson.M{"photo": 0}}},
+	}
+	c, err := d.collection(ctx).Aggregate(ctx, pipeline, aggregateOptions(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.All(ctx, &similar); err != nil {
+		return nil, err
+	}
+	for i := range similar {
+		similar[i].omitPhoto = true
+	}
+	return &similar, nil
+}
+
+// completionTimeline() for count the done travels which are not archived per day, week or month of
+// their updated_at in loc, oldest first. Weeks start on Monday. $dateTrunc needs MongoDB 5.0.
+func (d *DBRepository) completionTimeline(ctx context.Context, bucket string, loc *time.Location) (*[]PeriodCount, error) {
+	filter := bson.M{"done": true, "archived": bson.M{"$ne": true}, "updated_at": bson.M{"$exists": true}}
+	defer d.observe(ctx, "completionTimeline", filter, time.Now())
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"$dateTrunc": bson.M{
+				"date":        "$updated_at",
+				"unit":        bucket,
+				"timezone":    loc.String(),
+				"startOfWeek": "monday",
+			}},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	c, err := d.collection(ctx).Aggregate(ctx, pipeline, aggregateOptions(ctx))
+	if err != nil {
+		return nil, err
+	}
+	counts := []PeriodCount{}
+	if err := c.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+// countByMonth() for count the travels matching the filter per month of creation (UTC), oldest first.
+// Travels written before created_at existed are counted by the time of their ObjectID.
+func (d *DBRepository) countByMonth(ctx context.Context, filter bson.M) (*[]MonthCount, error) {
+	defer d.observe(ctx, "countByMonth", filter, time.Now())
+	createdAt := bson.M{"$ifNull": bson.A{"$created_at", bson.M{"$toDate": "$_id"}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": createdAt}},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	c, err := d.collection(ctx).Aggregate(ctx, pipeline, aggregateOptions(ctx))
+	if err != nil {
+		return nil, err
+	}
+	counts := []MonthCount{}
+	if err := c.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+// doneTimes() for the distinct updated_at of the done travels that are not archived,
+// the time a travel was last written stands for when it was done
+func (d *DBRepository) doneTimes(ctx context.Context) ([]time.Time, error) {
+	filter := bson.M{"done": true, "archived": bson.M{"$ne": true}, "updated_at": bson.M{"$exists": true}}
+	defer d.observe(ctx, "doneTimes", filter, time.Now())
+	values, err := d.collection(ctx).Distinct(ctx, "updated_at", filter)
+	if err != nil {
+		return nil, err
+	}
+	times := make([]time.Time, 0, len(values))
+	for _, value := range values {
+		if t, ok := value.(primitive.DateTime); ok {
+			times = append(times, t.Time())
+		}
+	}
+	return times, nil
+}
+
+// distinctPhotos() for the distinct non-empty photo URLs of the travels
+func (d *DBRepository) distinctPhotos(ctx context.Context) ([]string, error) {
+	filter := bson.M{"photo": bson.M{"$nin": bson.A{"", nil}}}
+	defer d.observe(ctx, "distinctPhotos", filter, time.Now())
+	values, err := d.collection(ctx).Distinct(ctx, "photo", filter)
+	if err != nil {
+		return nil, err
+	}
+	photos := make([]string, 0, len(values))
+	for _, value := range values {
+		if photo, ok := value.(string); ok {
+			photos = append(photos, photo)
+		}
+	}
+	return photos, nil
+}
+
+// count() for count the travels matching the filter
+func (d *DBRepository) count(ctx context.Context, filter bson.M) (int64, error) {
+	defer d.observe(ctx, "count", filter, time.Now())
+	return d.collection(ctx).CountDocuments(ctx, filter)
+}
+
+// insertOne() for insert a data to collection, an id is generated when none is supplied
+func (d *DBRepository) insertOne(ctx context.Context, travel *Travel) error {
+	defer d.observe(ctx, "insertOne", nil, time.Now())
+	if travel.ObjectID.IsZero() {
+		travel.ObjectID = primitive.NewObjectID()
+	}
+	travel.CreatedAt = now()
+	travel.UpdatedAt = travel.CreatedAt
+	travel.LockedBy = ""
+	travel.LockedAt = nil
+	travel.Views = 0
+	base := slugify(travel.Name)
+	for attempt := 1; ; attempt++ {
+		slug, err := d.availableSlug(ctx, base, nil)
+		if err != nil {
+			return err
+		}
+		travel.Slug = slug
+		_, err = d.collection(ctx).InsertOne(ctx, travel)
+		// a concurrent insert took the slug, the next free one is tried
+		if isSlugTaken(err) && attempt < 3 {
+			continue
+		}
+		if mongo.IsDuplicateKeyError(err) && !isSlugTaken(err) {
+			return errTravelConflict
+		}
+		return err
+	}
+}
+
+// availableSlug() for the slug, or the slug with the lowest numeric suffix from 2, which no travel has
+// and which is not taken
+func (d *DBRepository) availableSlug(ctx context.Context, slug string, taken map[string]bool) (string, error) {
+	pattern := "^" + regexp.QuoteMeta(slug) + "(-[0-9]+)?$"
+	values, err := d.collection(ctx).Distinct(ctx, "slug", bson.M{"slug": primitive.Regex{Pattern: pattern}})
+	if err != nil {
+		return "", err
+	}
+	used := map[string]bool{}
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			used[s] = true
+		}
+	}
+	return freeSlug(slug, used, taken), nil
+}
+
+// freeSlug() for the slug, or the slug with the lowest numeric suffix from 2, which is neither used nor taken
+func freeSlug(slug string, used, taken map[string]bool) string {
+	candidate := slug
+	for n := 2; used[candidate] || taken[candidate]; n++ {
+		candidate = fmt.Sprintf("%s-%d", slug, n)
+	}
+	return candidate
+}
+
+// isSlugTaken() for check a write failed on the unique slug index
+func isSlugTaken(err error) bool {
+	return mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "travel_slug")
+}
+
+// insertMany() for insert travels in one unordered write, it returns how many were inserted
+func (d *DBRepository) insertMany(ctx context.Context, travels []Travel) (int, error) {
+	defer d.observe(ctx, "insertMany", nil, time.Now())
+	if len(travels) == 0 {
+		return 0, nil
+	}
+	createdAt := now()
+	docs := make([]interface{}, len(travels))
+	// travels of the batch may share a slug too
+	taken := map[string]bool{}
+	for i := range travels {
+		slug, err := d.availableSlug(ctx, slugify(travels[i].Name), taken)
+		if err != nil {
+			return 0, err
+		}
+		taken[slug] = true
+		travels[i].ObjectID = primitive.NewObjectID()
+		travels[i].Slug = slug
+		travels[i].CreatedAt = createdAt
+		travels[i].UpdatedAt = createdAt
+		travels[i].Views = 0
+		docs[i] = travels[i]
+	}
+	_, err := d.collection(ctx).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		return len(docs) - len(bulkErr.WriteErrors), err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
+
+// updateOne() for update a data in collection, a version makes it conditional on the stored updated_at.
+// A travel which does not exist is errTravelNotFound.
+func (d *DBRepository) updateOne(ctx context.Context, id string, travel *Travel, version *time.Time) error {
+	defer d.observe(ctx, "updateOne", bson.M{"_id": id}, time.Now())
+	filter, update := setTravel(ctx, id, travel, version)
+	res, err := d.collection(ctx).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return d.conditionFailed(ctx, travel.ObjectID)
+	}
+	return nil
+}
+
+// updateOneAndReturn() for update a data in collection and return the updated document
+func (d *DBRepository) updateOneAndReturn(ctx context.Context, id string, travel *Travel, version *time.Time) (*Travel, error) {
+	defer d.observe(ctx, "updateOneAndReturn", bson.M{"_id": id}, time.Now())
+	filter, update := setTravel(ctx, id, travel, version)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated Travel
+	if err := d.collection(ctx).FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, d.conditionFailed(ctx, travel.ObjectID)
+		}
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// setTravel() for the filter and update of a travel, the slug, created_at, position, archived, the
+// lock and the views are kept as stored. A travel locked by another actor is not matched.
+func setTravel(ctx context.Context, id string, travel *Travel, version *time.Time) (bson.M, bson.M) {
+	travel.ObjectID, _ = primitive.ObjectIDFromHex(id)
+	travel.Slug = ""
+	travel.CreatedAt = time.Time{}
+	travel.Position = 0
+	travel.Archived = false
+	travel.LockedBy = ""
+	travel.LockedAt = nil
+	travel.Views = 0
+	travel.UpdatedAt = now()
+	return versionFilter(writableFilter(ctx, travel.ObjectID), version), bson.M{"$set": travel}
+}
+
+// versionFilter() for match the updated_at a conditional write expects, a zero version matches
+// legacy documents without updated_at
+func versionFilter(filter bson.M, version *time.Time) bson.M {
+	switch {
+	case version == nil:
+	case version.IsZero():
+		filter["updated_at"] = bson.M{"$exists": false}
+	default:
+		filter["updated_at"] = *version
+	}
+	return filter
+}
+
+// unmodifiedSinceFilter() for match a travel not updated after since, compared to the second as HTTP
+// dates have whole seconds. A nil since matches any, and legacy documents without updated_at match.
+func unmodifiedSinceFilter(filter bson.M, since *time.Time) bson.M {
+	if since != nil {
+		filter["updated_at"] = bson.M{"$not": bson.M{"$gte": since.Add(time.Second)}}
+	}
+	return filter
+}
+
+// writableFilter() for match a travel the actor of ctx may write, one not locked by another actor
+func writableFilter(ctx context.Context, objectID primitive.ObjectID) bson.M {
+	return lockableFilter(objectID, contextActor(ctx), lockTTL())
+}
+
+// conditionFailed() for the error of a write which matched nothing: the travel is missing, locked by
+// another actor, or was changed since the version the write expected
+func (d *DBRepository) conditionFailed(ctx context.Context, objectID primitive.ObjectID) error {
+	travel, err := d.findOne(ctx, objectID.Hex())
+	if err != nil {
+		return notFound(err)
+	}
+	if err := lockedByOther(travel, contextActor(ctx)); err != nil {
+		return err
+	}
+	return errPreconditionFailed
+}
+
+// clock for the current time of the cache expiry, the locks and now(), tests replace it
+var clock = time.Now
+
+// now() for the current time as MongoDB stores it, UTC with millisecond precision
+func now() time.Time {
+	return clock().UTC().Truncate(time.Millisecond)
+}
+
+// incrementViews() for add one to the views of a travel, it returns the new count. Only the count is
+// read back, and updated_at is left alone, a view is no change.
+func (d *DBRepository) incrementViews(ctx context.Context, id string) (int64, error) {
+	defer d.observe(ctx, "incrementViews", bson.M{"_id": id}, time.Now())
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return 0, errTravelNotFound
+	}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"views": 1})
+	var travel Travel
+	err = d.collection(ctx).FindOneAndUpdate(ctx, bson.M{"_id": objectID}, bson.M{"$inc": bson.M{"views": 1}}, opts).Decode(&travel)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, errTravelNotFound
+	}
+	return travel.Views, err
+}
+
+// updateField() for update a field, of a travel not locked by another actor
+func (d *DBRepository) updateField(ctx context.Context, id, field string, value interface{}) error {
+	defer d.observe(ctx, "updateField", bson.M{"_id": id}, time.Now())
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errTravelNotFound
+	}
+	update := bson.M{"$set": bson.M{field: value, "updated_at": now()}}
+	res, err := d.collection(ctx).UpdateOne(ctx, writableFilter(ctx, objectID), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return d.conditionFailed(ctx, objectID)
+	}
+	return nil
+}
+
+// lockableFilter() for match a travel the actor may lock or write: unlocked, locked by the actor, or
+// with an expired lock
+func lockableFilter(objectID primitive.ObjectID, actor string, ttl time.Duration) bson.M {
+	return bson.M{"_id": objectID, "$or": bson.A{
+		bson.M{"locked_by": bson.M{"$exists": false}},
+		bson.M{"locked_by": actor},
+		bson.M{"locked_at": bson.M{"$lt": now().Add(-ttl)}},
+	}}
+}
+
+// lock() for lock a travel for editing by the actor, or renew the lock the actor holds.
+// It returns when the lock was taken.
+func (d *DBRepository) lock(ctx context.Context, id, actor string, ttl time.Duration) (time.Time, error) {
+	defer d.observe(ctx, "lock", bson.M{"_id": id}, time.Now())
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return time.Time{}, errTravelNotFound
+	}
+	lockedAt := now()
+	update := bson.M{"$set": bson.M{"locked_by": actor, "locked_at": lockedAt}}
+	res, err := d.collection(ctx).UpdateOne(ctx, lockableFilter(objectID, actor, ttl), update)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if res.MatchedCount == 0 {
+		return time.Time{}, d.lockFailed(ctx, objectID)
+	}
+	return lockedAt, nil
+}
+
+// unlock() for release the lock of a travel, a travel which is not locked is left as it is
+func (d *DBRepository) unlock(ctx context.Context, id, actor string, ttl time.Duration) error {
+	defer d.observe(ctx, "unlock", bson.M{"_id": id}, time.Now())
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errTravelNotFound
+	}
+	update := bson.M{"$unset": bson.M{"locked_by": "", "locked_at": ""}}
+	res, err := d.collection(ctx).UpdateOne(ctx, lockableFilter(objectID, actor, ttl), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return d.lockFailed(ctx, objectID)
+	}
+	return nil
+}
+
+// lockFailed() for the error of a lock or unlock which matched nothing
+func (d *DBRepository) lockFailed(ctx context.Context, objectID primitive.ObjectID) error {
+	found, err := d.exists(ctx, objectID.Hex())
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errTravelNotFound
+	}
+	return errTravelLocked
+}
+
+// patchOne() for set and unset fields of a travel not locked by another actor, a version makes it
+// conditional on the stored updated_at
+func (d *DBRepository) patchOne(ctx context.Context, id string, set bson.M, unset []string, version *time.Time) error {
+	defer d.observe(ctx, "patchOne", bson.M{"_id": id}, time.Now())
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errTravelNotFound
+	}
+	update := bson.M{}
+	if len(set) > 0 || len(unset) > 0 {
+		set["updated_at"] = now()
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		fields := bson.M{}
+		for _, field := range unset {
+			fields[field] = ""
+		}
+		update["$unset"] = fields
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	res, err := d.collection(ctx).UpdateOne(ctx, versionFilter(writableFilter(ctx, objectID), version), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return d.conditionFailed(ctx, objectID)
+	}
+	return nil
+}
+
+// updateMany() for set fields of every travel matching the filter, it returns the modified count
+func (d *DBRepository) updateMany(ctx context.Context, filter bson.M, set bson.M) (int64, error) {
+	defer d.observe(ctx, "updateMany", filter, time.Now())
+	set["updated_at"] = now()
+	res, err := d.collection(ctx).UpdateMany(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// tagModes for how assignTags changes the tags of a travel: add them, replace them all, or remove them
+var tagModes = []string{"add", "set", "remove"}
+
+// merge() for add the tags of the source travel to the travel of id and archive the source, in one
+// transaction, which needs a replica set. Both travels are read and checked for locks of other actors
+// and the tag limit inside it. It returns the travel of id before and after the merge.
+func (d *DBRepository) merge(ctx context.Context, id, sourceID string) (before, merged *Travel, err error) {
+	defer d.observe(ctx, "merge", bson.M{"_id": id, "source": sourceID}, time.Now())
+	session, err := d.client.StartSession()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer session.EndSession(ctx)
+
+	result, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		target, err := d.findOne(sc, id)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		source, err := d.findOne(sc, sourceID)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		for _, travel := range []*Travel{target, source} {
+			if err := lockedByOther(travel, contextActor(sc)); err != nil {
+				return nil, err
+			}
+		}
+		tags := mergeTags(target.Tags, source.Tags)
+		if len(tags) > maxTagsPerTravel() {
+			return nil, ValidationError{newFieldError("tags", "max_items", maxTagsPerTravel())}
+		}
+		original := *target
+		updatedAt := now()
+		target.Tags = tags
+		target.UpdatedAt = updatedAt
+		col := d.collection(sc)
+		if _, err := col.UpdateOne(sc, bson.M{"_id": target.ObjectID},
+			bson.M{"$set": bson.M{"tags": target.Tags, "updated_at": updatedAt}}); err != nil {
+			return nil, err
+		}
+		if _, err := col.UpdateOne(sc, bson.M{"_id": source.ObjectID},
+			bson.M{"$set": bson.M{"archived": true, "updated_at": updatedAt}}); err != nil {
+			return nil, err
+		}
+		return [2]*Travel{&original, target}, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	travels := result.([2]*Travel)
+	return travels[0], travels[1], nil
+}
+
+// notFound() for errTravelNotFound in place of a missing document or a malformed id
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, primitive.ErrInvalidHex) {
+		return errTravelNotFound
+	}
+	return err
+}
+
+// mergeTags() for the tags followed by the extra ones they do not have yet
+func mergeTags(tags, extra []string) []string {
+	seen := make(map[string]bool, len(tags)+len(extra))
+	merged := []string{}
+	for _, tag := range append(append([]string{}, tags...), extra...) {
+		if !seen[tag] {
+			seen[tag] = true
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+// tagsFit() for the $expr matching the travels which stay within maxTagsPerTravel() with tags added
+func tagsFit(tags []string) bson.M {
+	union := bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}, tags}}
+	return bson.M{"$lte": bson.A{bson.M{"$size": union}, maxTagsPerTravel()}}
+}
+
+// isTagMode() for check a mode is one of tagModes
+func isTagMode(mode string) bool {
+	for _, m := range tagModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// assignTags() for add, set or remove tags on the travels of ids, it returns the modified count
+func (d *DBRepository) assignTags(ctx context.Context, ids []primitive.ObjectID, tags []string, mode string) (int64, error) {
+	filter := bson.M{"_id": bson.M{"$in": ids}}
+	defer d.observe(ctx, "assignTags", filter, time.Now())
+	var update bson.M
+	switch mode {
+	case "add":
+		// a travel is left alone when the tags would not fit any more
+		filter["$expr"] = tagsFit(tags)
+		update = bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}}
+	case "set":
+		update = bson.M{"$set": bson.M{"tags": tags}}
+	case "remove":
+		update = bson.M{"$pull": bson.M{"tags": bson.M{"$in": tags}}}
+	default:
+		return 0, fmt.Errorf("unknown tag mode %q", mode)
+	}
+	if set, ok := update["$set"].(bson.M); ok {
+		set["updated_at"] = now()
+	} else {
+		update["$set"] = bson.M{"updated_at": now()}
+	}
+	res, err := d.collection(ctx).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// backfills for the fields travels written before they existed lack, in order, with either the
+// expression deriving each one from the document or, for what must match how Go derives it on write,
+// the Go function deriving it from the name
+var backfills = []struct {
+	field  string
+	value  interface{}
+	derive func(*Travel) bson.M
+}{
+	{field: "created_at", value: bson.M{"$toDate": "$_id"}},
+	{field: "updated_at", value: "$created_at"},
+	// MongoDB's $toLower only lowercases ASCII
+	{field: "name_normalized", derive: func(travel *Travel) bson.M {
+		return bson.M{"name_normalized": strings.ToLower(normalizeName(travel.Name))}
+	}},
+	{field: "status", value: bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$done", true}}, "done", "pending"}}},
+}
+
+// backfillFilter() for match the travels which lack a field
+func backfillFilter(field string) bson.M {
+	return bson.M{field: bson.M{"$exists": false}}
+}
+
+// backfill() for set a field the travels lack to the value expression, with a pipeline update.
+// It returns the modified count.
+func (d *DBRepository) backfill(ctx context.Context, field string, value interface{}) (int64, error) {
+	filter := backfillFilter(field)
+	defer d.observe(ctx, "backfill", filter, time.Now())
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{field: value}}}}
+	res, err := d.collection(ctx).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// backfillEach() for set the fields derive computes in Go on the travels which lack a field.
+// It returns the modified count.
+func (d *DBRepository) backfillEach(ctx context.Context, field string, derive func(*Travel) bson.M) (int64, error) {
+	filter := backfillFilter(field)
+	defer d.observe(ctx, "backfillEach", filter, time.Now())
+	return d.updateEach(ctx, filter, derive)
+}
+
+// renameFilter() for match the travels whose name contains find, case-sensitive like the replacement
+func renameFilter(find string) bson.M {
+	return bson.M{"name": primitive.Regex{Pattern: regexp.QuoteMeta(find)}}
+}
+
+// renameMany() for replace every occurrence of find in the names of travels. The new names are
+// normalized in Go as on create, MongoDB's $toLower only lowercases ASCII. It returns the modified count.
+func (d *DBRepository) renameMany(ctx context.Context, find, replace string) (int64, error) {
+	filter := renameFilter(find)
+	defer d.observe(ctx, "renameMany", filter, time.Now())
+	updatedAt := now()
+	return d.updateEach(ctx, filter, func(travel *Travel) bson.M {
+		name := normalizeName(strings.ReplaceAll(travel.Name, find, replace))
+		return bson.M{"name": name, "name_normalized": strings.ToLower(name), "updated_at": updatedAt}
+	})
+}
+
+// updateEachBatch for the number of updates updateEach() sends in one bulk write
+const updateEachBatch = 500
+
+// updateEach() for set the fields computed in Go by set on every travel matching the filter, read
+// with their name only, in bulk writes. A travel is left alone when its name changed since it was
+// read. It returns the modified count.
+func (d *DBRepository) updateEach(ctx context.Context, filter bson.M, set func(*Travel) bson.M) (int64, error) {
+	c, err := d.collection(ctx).Find(ctx, filter, findOptions(ctx).SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close(ctx)
+
+	var modified int64
+	var models []mongo.WriteModel
+	write := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		res, err := d.collection(ctx).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return err
+		}
+		modified += res.ModifiedCount
+		models = models[:0]
+		return nil
+	}
+	for c.Next(ctx) {
+		var travel Travel
+		if err := c.Decode(&travel); err != nil {
+			return modified, err
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": travel.ObjectID, "name": travel.Name}).
+			SetUpdate(bson.M{"$set": set(&travel)}))
+		if len(models) == updateEachBatch {
+			if err := write(); err != nil {
+				return modified, err
+			}
+		}
+	}
+	if err := c.Err(); err != nil {
+		return modified, err
+	}
+	return modified, write()
+}
+
+// deleteOne() for delete a data from coll, unless another actor locked it or, with since, it was
+// updated after since. It returns the deleted travel, a travel which does not exist is nil and no error.
+func (d *DBRepository) deleteOne(ctx context.Context, id string, since *time.Time) (*Travel, error) {
+	defer d.observe(ctx, "deleteOne", bson.M{"_id": id}, time.Now())
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var travel Travel
+	err = d.collection(ctx).FindOneAndDelete(ctx, unmodifiedSinceFilter(writableFilter(ctx, objectId), since)).Decode(&travel)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if err := d.conditionFailed(ctx, objectId); !errors.Is(err, errTravelNotFound) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &travel, nil
+}
+
+// reorder() for set the position of every travel from the order of ids, in a single bulk write
+func (d *DBRepository) reorder(ctx context.Context, ids []string) error {
+	defer d.observe(ctx, "reorder", nil, time.Now())
+	objectIDs := make(map[primitive.ObjectID]int, len(ids))
+	for i, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return errReorderMismatch
+		}
+		if _, ok := objectIDs[objectID]; ok {
+			return errReorderMismatch
+		}
+		objectIDs[objectID] = i
+	}
+
+	c, err := d.collection(ctx).Find(ctx, bson.D{}, findOptions(ctx).SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	stored := 0
+	for c.Next(ctx) {
+		var travel Travel
+		if err := c.Decode(&travel); err != nil {
+			return err
+		}
+		if _, ok := objectIDs[travel.ObjectID]; !ok {
+			return errReorderMismatch
+		}
+		stored++
+	}
+	if err := c.Close(ctx); err != nil {
+		return err
+	}
+	if stored != len(objectIDs) {
+		return errReorderMismatch
+	}
+
+	models := make([]mongo.WriteModel, 0, len(objectIDs))
+	for objectID, position := range objectIDs {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": objectID}).
+			SetUpdate(bson.M{"$set": bson.M{"position": position}}))
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	if _, err := d.collection(ctx).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// watch() for stream insert, update and delete changes from a change stream until ctx is done
+func (d *DBRepository) watch(ctx context.Context) (<-chan TravelEvent, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+	}}}}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	stream, err := d.collection(ctx).Watch(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan TravelEvent)
+	go func() {
+		defer close(events)
+		defer stream.Close(context.Background())
+
+		for stream.Next(ctx) {
+			var change struct {
+				OperationType string `bson:"operationType"`
+				DocumentKey   struct {
+					ID primitive.ObjectID `bson:"_id"`
+				} `bson:"documentKey"`
+				FullDocument *Travel `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				log.Println("change stream decode:", err)
+				return
+			}
+			event := TravelEvent{
+				Operation: change.OperationType,
+				ID:        change.DocumentKey.ID.Hex(),
+				Travel:    change.FullDocument,
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			log.Println("change stream:", err)
+		}
+	}()
+	return events, nil
+}
+
+// insertAudit() for append a record to the audit collection of the database the travel collection is in
+func (d *DBRepository) insertAudit(ctx context.Context, record *AuditRecord) error {
+	defer d.observe(ctx, "insertAudit", nil, time.Now())
+	record.ObjectID = primitive.NewObjectID()
+	_, err := d.collection(ctx).Database().Collection(auditCollection).InsertOne(ctx, record)
+	return err
+}
+
+// findAudit() for a page of the audit records matching the filter, newest first, and how many there are in total
+func (d *DBRepository) findAudit(ctx context.Context, filter bson.M, limit, offset int64) (*[]AuditRecord, int64, error) {
+	defer d.observe(ctx, "findAudit", filter, time.Now())
+	col := d.collection(ctx).Database().Collection(auditCollection)
+	total, err := col.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts := findOptions(ctx).
+		SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(offset).
+		SetLimit(limit)
+	c, err := col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	records := []AuditRecord{}
+	if err := c.All(ctx, &records); err != nil {
+		return nil, 0, err
+	}
+	return &records, total, nil
+}
+
+// Close Close() for close connection
+func (d *DBRepository) Close() {
+	if err := d.client.Disconnect(context.Background()); err != nil {
 		log.Fatal(err)
 	}
 }
 
-// appService struct for Travel repository
-type appService struct {
-	Repository Repository
+// tenantKey for the tenant id in a request context
+type tenantKey struct{}
+
+// tenants() for the allowed X-Tenant-ID values, tenancy is disabled when TENANT_IDS is empty
+func tenants() []string {
+	return envList("TENANT_IDS")
+}
+
+// envList() for the trimmed, non-empty values of a comma-separated env var
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// withTenant() for a context which routes the repository to the tenant database
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// actorKey for the context value of the actor of a request, the writes respect the locks of others
+type actorKey struct{}
+
+// contextActor() for the actor of a repository context, empty outside of a request
+func contextActor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// requestContext() for the repository context of a request, which carries its tenant, actor, request id
+// and span
+func requestContext(c *fiber.Ctx) context.Context {
+	ctx := context.Background()
+	if span, ok := c.Locals("span").(trace.Span); ok {
+		ctx = trace.ContextWithSpan(ctx, span)
+	}
+	if tenant, ok := c.Locals("tenant").(string); ok {
+		ctx = withTenant(ctx, tenant)
+	}
+	ctx = context.WithValue(ctx, actorKey{}, actor(c))
+	if id, ok := c.Locals("requestid").(string); ok {
+		// copied, the context may outlive the request and fiber reuses the header buffer
+		ctx = context.WithValue(ctx, requestIDKey{}, utils.CopyString(id))
+	}
+	// shared by every context of the request, serialize() reports it
+	stale, ok := c.Locals("stale").(*bool)
+	if !ok {
+		stale = new(bool)
+		c.Locals("stale", stale)
+	}
+	return context.WithValue(ctx, staleKey{}, stale)
+}
+
+// cachedRepository for a Repository which caches findOne results in memory for a short time.
+// Writes through it invalidate the cached travels they change.
+type cachedRepository struct {
+	Repository
+	ttl  time.Duration
+	size int
+	// serveStale answers a failed read with the last value read, even an expired one
+	serveStale bool
+	// loads shares one database read between concurrent misses of the same travel
+	loads singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order has the most recently used entry in front
+	order *list.List
+	// generation counts the invalidations, a read started before one must not be cached
+	generation uint64
+}
+
+// cacheEntry for a cached travel, or a list of travels kept to be served stale
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewCachedRepo for wrap a Repository with an LRU cache of size entries, each fresh for ttl.
+// With serveStale, expired entries are kept until evicted, and lists are cached too, to answer
+// reads which fail.
+func NewCachedRepo(r Repository, ttl time.Duration, size int, serveStale bool) Repository {
+	return &cachedRepository{
+		Repository: r,
+		ttl:        ttl,
+		size:       size,
+		serveStale: serveStale,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+// cacheKey() for the cache key of a travel, travels of different tenants never share one
+func cacheKey(ctx context.Context, id string) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant + "/" + id
+}
+
+// listCacheKey() for the cache key of a list, from its filter and options
+func listCacheKey(ctx context.Context, filter bson.M, opts listOptions) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	// fmt prints maps sorted by key, so equal filters give equal keys
+	return fmt.Sprintf("%s/list %v %+v", tenant, filter, opts)
+}
+
+// get() for a cached value and whether it has not expired yet
+func (r *cachedRepository) get(key string) (value interface{}, fresh, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	element, ok := r.entries[key]
+	if !ok {
+		return nil, false, false
+	}
+	entry := element.Value.(*cacheEntry)
+	fresh = clock().Before(entry.expires)
+	if !fresh && !r.serveStale {
+		r.order.Remove(element)
+		delete(r.entries, key)
+		return nil, false, false
+	}
+	r.order.MoveToFront(element)
+	return entry.value, fresh, true
+}
+
+// currentGeneration() for the generation a read starts in, to give to put
+func (r *cachedRepository) currentGeneration() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.generation
+}
+
+// put() for cache a value read in generation, the least recently used one is evicted when full.
+// A value read before an invalidation may predate a write and is not cached.
+// The value must not be changed afterwards, callers get copies of it.
+func (r *cachedRepository) put(key string, value interface{}, generation uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if generation != r.generation {
+		return
+	}
+	entry := &cacheEntry{key: key, value: value, expires: clock().Add(r.ttl)}
+	if element, ok := r.entries[key]; ok {
+		element.Value = entry
+		r.order.MoveToFront(element)
+		return
+	}
+	r.entries[key] = r.order.PushFront(entry)
+	if r.order.Len() > r.size {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate() for drop a cached travel
+func (r *cachedRepository) invalidate(ctx context.Context, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	key := cacheKey(ctx, id)
+	if element, ok := r.entries[key]; ok {
+		r.order.Remove(element)
+		delete(r.entries, key)
+	}
+}
+
+// flush() for drop every cached travel, after writes which change many of them
+func (r *cachedRepository) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	r.entries = map[string]*list.Element{}
+	r.order.Init()
+}
+
+// canServeStale() for check a failed read may be answered from the cache, a travel which is gone
+// must not come back
+func (r *cachedRepository) canServeStale(err error) bool {
+	return r.serveStale && !errors.Is(err, errTravelNotFound) && !errors.Is(err, mongo.ErrNoDocuments)
+}
+
+// findAll() for find all travels matching the filter, the last list read answers a failed read
+// when serveStale is set
+func (r *cachedRepository) findAll(ctx context.Context, filter bson.M, opts listOptions) (*Travels, error) {
+	if !r.serveStale {
+		return r.Repository.findAll(ctx, filter, opts)
+	}
+	key := listCacheKey(ctx, filter, opts)
+	generation := r.currentGeneration()
+	travels, err := r.Repository.findAll(ctx, filter, opts)
+	if err == nil {
+		r.put(key, append(Travels{}, *travels...), generation)
+		return travels, nil
+	}
+	if value, _, ok := r.get(key); ok && r.canServeStale(err) {
+		log.Printf("level=warn msg=\"serving stale list\" error=%v", err)
+		markStale(ctx)
+		stale := append(Travels{}, value.(Travels)...)
+		return &stale, nil
+	}
+	return nil, err
+}
+
+// findOne() for find a travel, from the cache when it is there
+func (r *cachedRepository) findOne(ctx context.Context, id string) (*Travel, error) {
+	key := cacheKey(ctx, id)
+	cached, fresh, ok := r.get(key)
+	if fresh {
+		travel := cached.(Travel)
+		return &travel, nil
+	}
+	// a caller arriving after an invalidation does not join a read started before it
+	generation := r.currentGeneration()
+	load := r.loads.DoChan(fmt.Sprintf("%s@%d", key, generation), func() (interface{}, error) {
+		// the read is shared, the caller which started it going away must not fail the others
+		loadCtx, cancel := context.WithTimeout(detachedContext{ctx}, cacheLoadTimeout)
+		defer cancel()
+
+		travel, err := r.Repository.findOne(loadCtx, id)
+		if err != nil {
+			return nil, err
+		}
+		r.put(key, *travel, generation)
+		return *travel, nil
+	})
+	var value interface{}
+	var err error
+	select {
+	case result := <-load:
+		value, err = result.Val, result.Err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		if !ok || !r.canServeStale(err) {
+			return nil, err
+		}
+		log.Printf("level=warn msg=\"serving stale travel\" id=%s error=%v", id, err)
+		markStale(ctx)
+		value = cached
+	}
+	// every caller gets its own copy of the shared travel
+	travel := value.(Travel)
+	return &travel, nil
+}
+
+// cacheLoadTimeout for how long a database read shared by concurrent cache misses may take
+const cacheLoadTimeout = 20 * time.Second
+
+// detachedContext for the values of a context, the tenant and the request id, without its
+// deadline and cancellation
+type detachedContext struct{ context.Context }
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+// staleKey for the flag of a request context which is set when a read was served from a stale cache
+type staleKey struct{}
+
+// markStale() for flag the request of ctx as served from a stale cache
+func markStale(ctx context.Context) {
+	if stale, ok := ctx.Value(staleKey{}).(*bool); ok {
+		*stale = true
+	}
+}
+
+func (r *cachedRepository) updateOne(ctx context.Context, id string, travel *Travel, version *time.Time) error {
+	defer r.invalidate(ctx, id)
+	return r.Repository.updateOne(ctx, id, travel, version)
+}
+
+func (r *cachedRepository) updateOneAndReturn(ctx context.Context, id string, travel *Travel, version *time.Time) (*Travel, error) {
+	defer r.invalidate(ctx, id)
+	return r.Repository.updateOneAndReturn(ctx, id, travel, version)
+}
+
+func (r *cachedRepository) updateField(ctx context.Context, id, field string, value interface{}) error {
+	defer r.invalidate(ctx, id)
+	return r.Repository.updateField(ctx, id, field, value)
+}
+
+func (r *cachedRepository) lock(ctx context.Context, id, actor string, ttl time.Duration) (time.Time, error) {
+	defer r.invalidate(ctx, id)
+	return r.Repository.lock(ctx, id, actor, ttl)
+}
+
+func (r *cachedRepository) unlock(ctx context.Context, id, actor string, ttl time.Duration) error {
+	defer r.invalidate(ctx, id)
+	return r.Repository.unlock(ctx, id, actor, ttl)
+}
+
+func (r *cachedRepository) patchOne(ctx context.Context, id string, set bson.M, unset []string, version *time.Time) error {
+	defer r.invalidate(ctx, id)
+	return r.Repository.patchOne(ctx, id, set, unset, version)
+}
+
+func (r *cachedRepository) updateMany(ctx context.Context, filter bson.M, set bson.M) (int64, error) {
+	defer r.flush()
+	return r.Repository.updateMany(ctx, filter, set)
+}
+
+func (r *cachedRepository) assignTags(ctx context.Context, ids []primitive.ObjectID, tags []string, mode string) (int64, error) {
+	defer r.flush()
+	return r.Repository.assignTags(ctx, ids, tags, mode)
+}
+
+func (r *cachedRepository) merge(ctx context.Context, id, sourceID string) (before, merged *Travel, err error) {
+	defer r.invalidate(ctx, id)
+	defer r.invalidate(ctx, sourceID)
+	return r.Repository.merge(ctx, id, sourceID)
+}
+
+func (r *cachedRepository) backfill(ctx context.Context, field string, value interface{}) (int64, error) {
+	defer r.flush()
+	return r.Repository.backfill(ctx, field, value)
+}
+
+func (r *cachedRepository) backfillEach(ctx context.Context, field string, derive func(*Travel) bson.M) (int64, error) {
+	defer r.flush()
+	return r.Repository.backfillEach(ctx, field, derive)
+}
+
+func (r *cachedRepository) renameMany(ctx context.Context, find, replace string) (int64, error) {
+	defer r.flush()
+	return r.Repository.renameMany(ctx, find, replace)
+}
+
+func (r *cachedRepository) deleteOne(ctx context.Context, id string, since *time.Time) (*Travel, error) {
+	defer r.invalidate(ctx, id)
+	return r.Repository.deleteOne(ctx, id, since)
+}
+
+func (r *cachedRepository) reorder(ctx context.Context, ids []string) error {
+	defer r.flush()
+	return r.Repository.reorder(ctx, ids)
+}
+
+// failoverRepository for a Repository which retries an operation once when it failed because
+// the primary stepped down, so the request is served by the newly elected primary.
+// It covers reads and the writes which are idempotent, a write applied twice leaves the travels
+// as applying it once does. Inserts, view counts, renames, deletes and writes guarded by a version
+// are not retried: the failed attempt may have been applied, and a retried delete would find nothing
+// and lose the deleted travel its audit record needs.
+type failoverRepository struct {
+	Repository
+}
+
+// NewFailoverRepo for wrap a Repository with a single retry on primary failover
+func NewFailoverRepo(r Repository) Repository {
+	return &failoverRepository{Repository: r}
+}
+
+// failoverCodes for the server errors of a primary which stepped down or is no longer primary.
+// An interrupted write may still have been applied.
+var failoverCodes = []int{
+	10107, // NotWritablePrimary
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+	11602, // InterruptedDueToReplStateChange
+	189,   // PrimarySteppedDown
+}
+
+// isFailover() for check an error is caused by a primary failover
+func isFailover(err error) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, code := range failoverCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// retry() for run an operation again once after a primary failover, the driver selects the new primary
+func (r *failoverRepository) retry(ctx context.Context, operation string, do func() error) error {
+	err := do()
+	if !isFailover(err) || ctx.Err() != nil {
+		return err
+	}
+	log.Printf("level=warn msg=\"primary failover, retrying\" operation=%s error=%v", operation, err)
+	return do()
+}
+
+func (r *failoverRepository) findAll(ctx context.Context, filter bson.M, opts listOptions) (travels *Travels, err error) {
+	err = r.retry(ctx, "findAll", func() error {
+		travels, err = r.Repository.findAll(ctx, filter, opts)
+		return err
+	})
+	return travels, err
+}
+
+func (r *failoverRepository) findPage(ctx context.Context, filter bson.M, opts listOptions) (travels *Travels, total int64, err error) {
+	err = r.retry(ctx, "findPage", func() error {
+		travels, total, err = r.Repository.findPage(ctx, filter, opts)
+		return err
+	})
+	return travels, total, err
+}
+
+func (r *failoverRepository) findOne(ctx context.Context, id string) (travel *Travel, err error) {
+	err = r.retry(ctx, "findOne", func() error {
+		travel, err = r.Repository.findOne(ctx, id)
+		return err
+	})
+	return travel, err
+}
+
+func (r *failoverRepository) findBySlug(ctx context.Context, slug string) (travel *Travel, err error) {
+	err = r.retry(ctx, "findBySlug", func() error {
+		travel, err = r.Repository.findBySlug(ctx, slug)
+		return err
+	})
+	return travel, err
+}
+
+func (r *failoverRepository) exists(ctx context.Context, id string) (found bool, err error) {
+	err = r.retry(ctx, "exists", func() error {
+		found, err = r.Repository.exists(ctx, id)
+		return err
+	})
+	return found, err
+}
+
+func (r *failoverRepository) findRandom(ctx context.Context, filter bson.M) (travel *Travel, err error) {
+	err = r.retry(ctx, "findRandom", func() error {
+		travel, err = r.Repository.findRandom(ctx, filter)
+		return err
+	})
+	return travel, err
+}
+
+func (r *failoverRepository) findNext(ctx context.Context) (travel *Travel, err error) {
+	err = r.retry(ctx, "findNext", func() error {
+		travel, err = r.Repository.findNext(ctx)
+		return err
+	})
+	return travel, err
+}
+
+func (r *failoverRepository) findRecent(ctx context.Context, limit int64) (travels *Travels, err error) {
+	err = r.retry(ctx, "findRecent", func() error {
+		travels, err = r.Repository.findRecent(ctx, limit)
+		return err
+	})
+	return travels, err
+}
+
+func (r *failoverRepository) textSearch(ctx context.Context, filter bson.M, limit, offset int64) (travels *[]ScoredTravel, err error) {
+	err = r.retry(ctx, "textSearch", func() error {
+		travels, err = r.Repository.textSearch(ctx, filter, limit, offset)
+		return err
+	})
+	return travels, err
+}
+
+func (r *failoverRepository) countTags(ctx context.Context, filter bson.M) (counts *[]TagCount, err error) {
+	err = r.retry(ctx, "countTags", func() error {
+		counts, err = r.Repository.countTags(ctx, filter)
+		return err
+	})
+	return counts, err
+}
+
+func (r *failoverRepository) popular(ctx context.Context, by string, limit int64) (counts *[]PopularCount, err error) {
+	err = r.retry(ctx, "popular", func() error {
+		counts, err = r.Repository.popular(ctx, by, limit)
+		return err
+	})
+	return counts, err
+}
+
+func (r *failoverRepository) similar(ctx context.Context, travel *Travel, limit int64) (travels *[]SimilarTravel, err error) {
+	err = r.retry(ctx, "similar", func() error {
+		travels, err = r.Repository.similar(ctx, travel, limit)
+		return err
+	})
+	return travels, err
+}
+
+func (r *failoverRepository) completionTimeline(ctx context.Context, bucket string, loc *time.Location) (counts *[]PeriodCount, err error) {
+	err = r.retry(ctx, "completionTimeline", func() error {
+		counts, err = r.Repository.completionTimeline(ctx, bucket, loc)
+		return err
+	})
+	return counts, err
+}
+
+func (r *failoverRepository) countByMonth(ctx context.Context, filter bson.M) (counts *[]MonthCount, err error) {
+	err = r.retry(ctx, "countByMonth", func() error {
+		counts, err = r.Repository.countByMonth(ctx, filter)
+		return err
+	})
+	return counts, err
+}
+
+func (r *failoverRepository) doneTimes(ctx context.Context) (times []time.Time, err error) {
+	err = r.retry(ctx, "doneTimes", func() error {
+		times, err = r.Repository.doneTimes(ctx)
+		return err
+	})
+	return times, err
+}
+
+func (r *failoverRepository) distinctPhotos(ctx context.Context) (photos []string, err error) {
+	err = r.retry(ctx, "distinctPhotos", func() error {
+		photos, err = r.Repository.distinctPhotos(ctx)
+		return err
+	})
+	return photos, err
+}
+
+func (r *failoverRepository) count(ctx context.Context, filter bson.M) (n int64, err error) {
+	err = r.retry(ctx, "count", func() error {
+		n, err = r.Repository.count(ctx, filter)
+		return err
+	})
+	return n, err
+}
+
+func (r *failoverRepository) updateOne(ctx context.Context, id string, travel *Travel, version *time.Time) error {
+	if version != nil {
+		// a second attempt would fail the version check of the first one
+		return r.Repository.updateOne(ctx, id, travel, version)
+	}
+	return r.retry(ctx, "updateOne", func() error {
+		return r.Repository.updateOne(ctx, id, travel, version)
+	})
+}
+
+func (r *failoverRepository) updateOneAndReturn(ctx context.Context, id string, travel *Travel, version *time.Time) (updated *Travel, err error) {
+	if version != nil {
+		return r.Repository.updateOneAndReturn(ctx, id, travel, version)
+	}
+	err = r.retry(ctx, "updateOneAndReturn", func() error {
+		updated, err = r.Repository.updateOneAndReturn(ctx, id, travel, version)
+		return err
+	})
+	return updated, err
+}
+
+func (r *failoverRepository) updateField(ctx context.Context, id, field string, value interface{}) error {
+	return r.retry(ctx, "updateField", func() error {
+		return r.Repository.updateField(ctx, id, field, value)
+	})
+}
+
+func (r *failoverRepository) lock(ctx context.Context, id, actor string, ttl time.Duration) (lockedAt time.Time, err error) {
+	err = r.retry(ctx, "lock", func() error {
+		lockedAt, err = r.Repository.lock(ctx, id, actor, ttl)
+		return err
+	})
+	return lockedAt, err
+}
+
+func (r *failoverRepository) unlock(ctx context.Context, id, actor string, ttl time.Duration) error {
+	return r.retry(ctx, "unlock", func() error {
+		return r.Repository.unlock(ctx, id, actor, ttl)
+	})
+}
+
+func (r *failoverRepository) patchOne(ctx context.Context, id string, set bson.M, unset []string, version *time.Time) error {
+	if version != nil {
+		// a second attempt would fail the version check of the first one
+		return r.Repository.patchOne(ctx, id, set, unset, version)
+	}
+	return r.retry(ctx, "patchOne", func() error {
+		return r.Repository.patchOne(ctx, id, set, unset, version)
+	})
+}
+
+func (r *failoverRepository) assignTags(ctx context.Context, ids []primitive.ObjectID, tags []string, mode string) (modified int64, err error) {
+	err = r.retry(ctx, "assignTags", func() error {
+		modified, err = r.Repository.assignTags(ctx, ids, tags, mode)
+		return err
+	})
+	return modified, err
+}
+
+func (r *failoverRepository) merge(ctx context.Context, id, sourceID string) (before, merged *Travel, err error) {
+	err = r.retry(ctx, "merge", func() error {
+		before, merged, err = r.Repository.merge(ctx, id, sourceID)
+		return err
+	})
+	return before, merged, err
+}
+
+func (r *failoverRepository) backfill(ctx context.Context, field string, value interface{}) (modified int64, err error) {
+	err = r.retry(ctx, "backfill", func() error {
+		modified, err = r.Repository.backfill(ctx, field, value)
+		return err
+	})
+	return modified, err
+}
+
+func (r *failoverRepository) backfillEach(ctx context.Context, field string, derive func(*Travel) bson.M) (modified int64, err error) {
+	err = r.retry(ctx, "backfillEach", func() error {
+		modified, err = r.Repository.backfillEach(ctx, field, derive)
+		return err
+	})
+	return modified, err
+}
+
+func (r *failoverRepository) updateMany(ctx context.Context, filter bson.M, set bson.M) (modified int64, err error) {
+	err = r.retry(ctx, "updateMany", func() error {
+		modified, err = r.Repository.updateMany(ctx, filter, set)
+		return err
+	})
+	return modified, err
+}
+
+func (r *failoverRepository) reorder(ctx context.Context, ids []string) error {
+	return r.retry(ctx, "reorder", func() error {
+		return r.Repository.reorder(ctx, ids)
+	})
+}
+
+func (r *failoverRepository) findAudit(ctx context.Context, filter bson.M, limit, offset int64) (records *[]AuditRecord, total int64, err error) {
+	err = r.retry(ctx, "findAudit", func() error {
+		records, total, err = r.Repository.findAudit(ctx, filter, limit, offset)
+		return err
+	})
+	return records, total, err
+}
+
+// appService struct for Travel repository
+type appService struct {
+	Repository Repository
+
+	// popularMu guards popular, the answers of getPopular kept for popularTTL
+	popularMu sync.Mutex
+	popular   map[string]popularEntry
+}
+
+// popularEntry for a cached answer of getPopular
+type popularEntry struct {
+	counts  []PopularCount
+	expires time.Time
+}
+
+// popularTTL for how long getPopular answers from the last aggregation
+const popularTTL = time.Minute
+
+// Service for Travel service interfaces
+type Service interface {
+	ready(c *fiber.Ctx) error
+	getTravels(c *fiber.Ctx) error
+	getTravel(c *fiber.Ctx) error
+	getTravelBySlug(c *fiber.Ctx) error
+	headTravel(c *fiber.Ctx) error
+	getRandomTravel(c *fiber.Ctx) error
+	getNextTravel(c *fiber.Ctx) error
+	textSearchTravels(c *fiber.Ctx) error
+	countTravelTags(c *fiber.Ctx) error
+	countTravelsByMonth(c *fiber.Ctx) error
+	getTravelHistory(c *fiber.Ctx) error
+	getSimilarTravels(c *fiber.Ctx) error
+	assignTravelTags(c *fiber.Ctx) error
+	mergeTravel(c *fiber.Ctx) error
+	viewTravel(c *fiber.Ctx) error
+	getDoneStreak(c *fiber.Ctx) error
+	getCompletionTimeline(c *fiber.Ctx) error
+	getPopular(c *fiber.Ctx) error
+	getTravelsCreatedBetween(c *fiber.Ctx) error
+	getTravelsFeed(c *fiber.Ctx) error
+	exportTravels(c *fiber.Ctx) error
+	streamTravelEvents(c *fiber.Ctx) error
+	createTravel(c *fiber.Ctx) error
+	createTravels(c *fiber.Ctx) error
+	validateTravel(c *fiber.Ctx) error
+	importTravels(c *fiber.Ctx) error
+	updateTravel(c *fiber.Ctx) error
+	patchTravel(c *fiber.Ctx) error
+	deleteTravel(c *fiber.Ctx) error
+	reorderTravels(c *fiber.Ctx) error
+	bulkDoneTravels(c *fiber.Ctx) error
+	archiveTravel(c *fiber.Ctx) error
+	unarchiveTravel(c *fiber.Ctx) error
+	lockTravel(c *fiber.Ctx) error
+	unlockTravel(c *fiber.Ctx) error
+	getPhotoHosts(c *fiber.Ctx) error
+	renameTravels(c *fiber.Ctx) error
+	migrateTravels(c *fiber.Ctx) error
+	getUserActivity(c *fiber.Ctx) error
+}
+
+// NewService for initialize service
+func NewService(r Repository) Service {
+	return &appService{Repository: r, popular: map[string]popularEntry{}}
+}
+
+// ready() for check the app can serve requests: the database answers and, unless READY_CHECK_INDEXES
+// is false, the managed indexes exist in the database of every tenant
+func (a *appService) ready(c *fiber.Ctx) error {
+	if _, err := a.Repository.ping(); err != nil {
+		return c.Status(http.StatusServiceUnavailable).JSON(map[string]interface{}{
+			"ready": false,
+			"error": err.Error(),
+		})
+	}
+	if os.Getenv("READY_CHECK_INDEXES") == "false" {
+		return c.JSON(map[string]interface{}{"ready": true})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	missing := []string{}
+	contexts := map[string]context.Context{"": ctx}
+	for _, tenant := range tenants() {
+		contexts[tenant] = withTenant(ctx, tenant)
+	}
+	for tenant, tenantCtx := range contexts {
+		names, err := a.Repository.missingIndexes(tenantCtx)
+		if err != nil {
+			return c.Status(http.StatusServiceUnavailable).JSON(map[string]interface{}{
+				"ready": false,
+				"error": err.Error(),
+			})
+		}
+		for _, name := range names {
+			if tenant != "" {
+				name = tenant + "/" + name
+			}
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return c.Status(http.StatusServiceUnavailable).JSON(map[string]interface{}{
+			"ready":   false,
+			"error":   "indexes are missing",
+			"missing": missing,
+		})
+	}
+	return c.JSON(map[string]interface{}{"ready": true})
+}
+
+// getTravels() for get Travels
+func (a *appService) getTravels(c *fiber.Ctx) error {
+	filter, err := travelFilter(c)
+	if err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	if c.Query("includeArchived") != "true" {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+
+	defer cancel()
+
+	opts := listOptions{withPhoto: c.Query("withPhoto") == "true"}
+	if sort := c.Query("sort"); sort != "" {
+		opts.sort, err = parseSort(sort)
+	} else {
+		opts.sort, err = defaultSort()
+	}
+	if err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	if opts.limit, opts.offset, err = parsePage(c); err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	// withTotal answers {data, total}, the page and the count are read in one query
+	if c.Query("withTotal") == "true" {
+		// the page is a single document of the $facet, which must stay under 16MB
+		if opts.limit == 0 || opts.limit > maxFacetLimit {
+			return response(nil, http.StatusBadRequest,
+				fmt.Errorf("withTotal needs a limit from 1 to %d", maxFacetLimit), c)
+		}
+		travels, total, err := a.Repository.findPage(ctx, filter, opts)
+		if err != nil {
+			return response(nil, http.StatusOK, err, c)
+		}
+		if opts.withPhoto {
+			for i := range *travels {
+				defaultPhoto(&(*travels)[i])
+			}
+		}
+		return response(travelPage{Data: travels, Total: total}, http.StatusOK, nil, c)
+	}
+	travels, err := a.Repository.findAll(ctx, filter, opts)
+	if err == nil && opts.withPhoto {
+		for i := range *travels {
+			defaultPhoto(&(*travels)[i])
+		}
+	}
+	return response(travels, http.StatusOK, err, c)
+}
+
+// getTravel() for get a Travel
+func (a *appService) getTravel(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return response(nil, http.StatusUnprocessableEntity, errors.New("id is not defined"), c)
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	travel, err := a.Repository.findOne(ctx, id)
+	if err == nil {
+		c.Set(fiber.HeaderETag, travelETag(travel))
+		if !travel.UpdatedAt.IsZero() {
+			c.Set(fiber.HeaderLastModified, travel.UpdatedAt.UTC().Format(http.TimeFormat))
+		}
+		defaultPhoto(travel)
+	}
+	return response(travel, http.StatusOK, err, c)
+}
+
+// getTravelBySlug() for get a Travel by its slug
+func (a *appService) getTravelBySlug(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	travel, err := a.Repository.findBySlug(ctx, c.Params("slug"))
+	if err == nil {
+		c.Set(fiber.HeaderETag, travelETag(travel))
+		defaultPhoto(travel)
+	}
+	return response(travel, http.StatusOK, err, c)
+}
+
+// travelETag() for the ETag of a Travel, derived from its updated_at
+func travelETag(travel *Travel) string {
+	if travel.UpdatedAt.IsZero() {
+		return `"0"`
+	}
+	return fmt.Sprintf(`"%d"`, travel.UpdatedAt.UnixNano()/int64(time.Millisecond))
+}
+
+// ifMatch() for the version an If-Match header expects, nil when the write is unconditional.
+// ok is false for an ETag this API never issued, which cannot match.
+func ifMatch(c *fiber.Ctx) (version *time.Time, ok bool) {
+	etag := strings.TrimPrefix(strings.TrimSpace(c.Get(fiber.HeaderIfMatch)), "W/")
+	if etag == "" || etag == "*" {
+		return nil, true
+	}
+	millis, err := strconv.ParseInt(strings.Trim(etag, `"`), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	var expected time.Time
+	if millis != 0 {
+		expected = time.Unix(0, millis*int64(time.Millisecond)).UTC()
+	}
+	return &expected, true
+}
+
+// headTravel() for check a Travel is exists, without body
+func (a *appService) headTravel(c *fiber.Ctx) error {
+	id := c.Params("id")
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	found, err := a.Repository.exists(ctx, id)
+	if err != nil {
+		return c.SendStatus(http.StatusInternalServerError)
+	}
+	if !found {
+		return c.SendStatus(http.StatusNotFound)
+	}
+	return c.SendStatus(http.StatusOK)
+}
+
+// getRandomTravel() for get a random Travel which is not archived, optionally within the filter
+func (a *appService) getRandomTravel(c *fiber.Ctx) error {
+	filter, err := travelFilter(c)
+	if err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	filter["archived"] = bson.M{"$ne": true}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	travel, err := a.Repository.findRandom(ctx, filter)
+	if err == nil {
+		defaultPhoto(travel)
+	}
+	return response(travel, http.StatusOK, err, c)
+}
+
+// getNextTravel() for get the oldest pending Travel
+func (a *appService) getNextTravel(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	travel, err := a.Repository.findNext(ctx)
+	if err == nil {
+		defaultPhoto(travel)
+	}
+	return response(travel, http.StatusOK, err, c)
+}
+
+// searchPageSize for the number of Travels of a text search page without a limit
+const searchPageSize = 20
+
+// textSearchTravels() for get a page of Travels ranked by text relevance, archived ones left out like a list
+func (a *appService) textSearchTravels(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return response(nil, http.StatusBadRequest, errors.New("q is not defined"), c)
+	}
+	limit, offset, err := parsePage(c)
+	if err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	if limit == 0 {
+		limit = searchPageSize
+	}
+	filter := bson.M{"$text": bson.M{"$search": q}}
+	if c.Query("includeArchived") != "true" {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	travels, err := a.Repository.textSearch(ctx, filter, limit, offset)
+	if err == nil {
+		for i := range *travels {
+			defaultPhoto(&(*travels)[i].Travel)
+		}
+	}
+	return response(travels, http.StatusOK, err, c)
+}
+
+// countTravelTags() for get the number of Travels per tag, within the list filter
+func (a *appService) countTravelTags(c *fiber.Ctx) error {
+	filter, err := travelFilter(c)
+	if err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	if c.Query("includeArchived") != "true" {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	counts, err := a.Repository.countTags(ctx, filter)
+	return response(counts, http.StatusOK, err, c)
+}
+
+// countTravelsByMonth() for get the number of Travels created per month, within the list filter
+func (a *appService) countTravelsByMonth(c *fiber.Ctx) error {
+	filter, err := travelFilter(c)
+	if err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	if c.Query("includeArchived") != "true" {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	counts, err := a.Repository.countByMonth(ctx, filter)
+	return response(counts, http.StatusOK, err, c)
+}
+
+// getPopular() for get the most common tags of Travels, or names with by=name, across all Travels.
+// The answer is cached for popularTTL, the aggregation reads every travel.
+func (a *appService) getPopular(c *fiber.Ctx) error {
+	by := c.Query("by", "tag")
+	if by != "tag" && by != "name" {
+		return response(nil, http.StatusBadRequest, errors.New("by must be tag or name"), c)
+	}
+	limit, err := strconv.ParseInt(c.Query("limit", "10"), 10, 64)
+	if err != nil || limit < 1 || limit > 100 {
+		return response(nil, http.StatusBadRequest, errors.New("limit must be a number from 1 to 100"), c)
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	key := fmt.Sprintf("%s %s %d", cacheKey(ctx, ""), by, limit)
+	a.popularMu.Lock()
+	entry, ok := a.popular[key]
+	a.popularMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return response(entry.counts, http.StatusOK, nil, c)
+	}
+
+	counts, err := a.Repository.popular(ctx, by, limit)
+	if err != nil {
+		return response(nil, http.StatusOK, err, c)
+	}
+	a.popularMu.Lock()
+	a.popular[key] = popularEntry{counts: *counts, expires: time.Now().Add(popularTTL)}
+	a.popularMu.Unlock()
+	return response(counts, http.StatusOK, nil, c)
+}
+
+// getCompletionTimeline() for get the number of Travels done per day, week or month, in statsLocation
+func (a *appService) getCompletionTimeline(c *fiber.Ctx) error {
+	bucket := c.Query("bucket", "day")
+	if bucket != "day" && bucket != "week" && bucket != "month" {
+		return response(nil, http.StatusBadRequest, errors.New("bucket must be day, week or month"), c)
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	counts, err := a.Repository.completionTimeline(ctx, bucket, statsLocation)
+	if err == nil && displayLocation != nil {
+		for i := range *counts {
+			(*counts)[i].Period = (*counts)[i].Period.In(displayLocation)
+		}
+	}
+	return response(counts, http.StatusOK, err, c)
+}
+
+// getDoneStreak() for get how many consecutive days at least one Travel was done, in statsLocation
+func (a *appService) getDoneStreak(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	times, err := a.Repository.doneTimes(ctx)
+	if err != nil {
+		return response(nil, http.StatusOK, err, c)
+	}
+	return response(doneStreak(times, now(), statsLocation), http.StatusOK, nil, c)
+}
+
+// statsLocation for the time zone the stats count days in, STATS_TZ (e.g. Asia/Jakarta) or UTC.
+// It is set once at startup.
+var statsLocation = time.UTC
+
+// loadStatsLocation() for the time zone of STATS_TZ, UTC when it is empty
+func loadStatsLocation() (*time.Location, error) {
+	name := os.Getenv("STATS_TZ")
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STATS_TZ: %w", err)
+	}
+	return loc, nil
+}
+
+// doneStreak() for the streak of the days in loc at least one of times falls on, up to today
+func doneStreak(times []time.Time, today time.Time, loc *time.Location) Streak {
+	days := map[string]bool{}
+	for _, t := range times {
+		days[t.In(loc).Format("2006-01-02")] = true
+	}
+	sorted := make([]string, 0, len(days))
+	for day := range days {
+		sorted = append(sorted, day)
+	}
+	sort.Strings(sorted)
+
+	streak := Streak{Days: len(sorted)}
+	if len(sorted) == 0 {
+		return streak
+	}
+	streak.LastDone = sorted[len(sorted)-1]
+
+	run := 0
+	var previous time.Time
+	for _, day := range sorted {
+		date, _ := time.ParseInLocation("2006-01-02", day, loc)
+		if run > 0 && previous.AddDate(0, 0, 1).Equal(date) {
+			run++
+		} else {
+			run = 1
+		}
+		if run > streak.Longest {
+			streak.Longest = run
+		}
+		previous = date
+	}
+
+	// the last run is current while it reaches today or yesterday
+	y, m, d := today.In(loc).Date()
+	todayDate := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	if !previous.Before(todayDate.AddDate(0, 0, -1)) {
+		streak.Current = run
+	}
+	return streak
+}
+
+// getTravelsCreatedBetween() for get Travels created from (inclusive) to (exclusive).
+// The range is on the timestamp of the ObjectID, so it covers travels written before created_at existed,
+// to the second.
+func (a *appService) getTravelsCreatedBetween(c *fiber.Ctx) error {
+	from, err := time.Parse(time.RFC3339, c.Query("from"))
+	if err != nil {
+		return response(nil, http.StatusBadRequest, errors.New("from must be an RFC 3339 time"), c)
+	}
+	to, err := time.Parse(time.RFC3339, c.Query("to"))
+	if err != nil {
+		return response(nil, http.StatusBadRequest, errors.New("to must be an RFC 3339 time"), c)
+	}
+	if !to.After(from) {
+		return response(nil, http.StatusBadRequest, errors.New("to must be after from"), c)
+	}
+	filter := bson.M{"_id": bson.M{
+		"$gte": primitive.NewObjectIDFromTimestamp(from),
+		"$lt":  primitive.NewObjectIDFromTimestamp(to),
+	}}
+	if c.Query("includeArchived") != "true" {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	travels, err := a.Repository.findAll(ctx, filter, listOptions{sort: bson.D{{Key: "_id", Value: 1}}})
+	return response(travels, http.StatusOK, err, c)
+}
+
+// feedSize for the number of entries in the travels feed
+const feedSize = 20
+
+// atomFeed for an Atom feed document, see RFC 4287
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomEntry for an entry of an Atom feed
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary,omitempty"`
+}
+
+// atomLink for a link of an Atom feed or entry
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+// getTravelsFeed() for get the most recent Travels as an Atom feed
+func (a *appService) getTravelsFeed(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	travels, err := a.Repository.findRecent(ctx, feedSize)
+	if err != nil {
+		return response(nil, http.StatusInternalServerError, err, c)
+	}
+
+	base := c.BaseURL() + "/api/v1/travels"
+	feed := atomFeed{
+		ID:      base,
+		Title:   "travelingo travels",
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: c.BaseURL() + c.OriginalURL(), Rel: "self"},
+	}
+	for i, travel := range *travels {
+		updated := travel.CreatedAt
+		if updated.IsZero() {
+			updated = travel.ObjectID.Timestamp()
+		}
+		if i == 0 {
+			feed.Updated = updated.UTC().Format(time.RFC3339)
+		}
+		link := base + "/" + travel.ObjectID.Hex()
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      link,
+			Title:   travel.Name,
+			Updated: updated.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: link},
+			Summary: travel.Description,
+		})
+	}
+
+	body, err := xml.Marshal(feed)
+	if err != nil {
+		return response(nil, http.StatusInternalServerError, err, c)
+	}
+	c.Set(fiber.HeaderContentType, "application/atom+xml; charset=utf-8")
+	return c.Send(append([]byte(xml.Header), body...))
+}
+
+// exportTravels() for stream the Travels matching the list filter as JSON Lines, read from the cursor as
+// they are written
+func (a *appService) exportTravels(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	filter, err := travelFilter(c)
+	if err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	if c.Query("includeArchived") != "true" {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+	requestCtx := requestContext(c)
+
+	c.Attachment("travels.jsonl")
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// the timeout starts with the stream, nothing is left to cancel when it is never written
+		ctx, cancel := context.WithTimeout(requestCtx, 10*time.Minute)
+		defer cancel()
+
+		encoder := json.NewEncoder(w)
+		err := a.Repository.forEach(ctx, filter, func(travel *Travel) error {
+			// Encode ends each travel with a newline
+			return encoder.Encode(travel)
+		})
+		if err != nil {
+			// the status is sent already, a cut short export is only logged
+			log.Println("export:", err)
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.Println("export:", err)
+		}
+	})
+	return nil
+}
+
+// streamTravelEvents() for push Travel changes to the client as server-sent events
+func (a *appService) streamTravelEvents(c *fiber.Ctx) error {
+	ctx, cancel := context.WithCancel(requestContext(c))
+	events, err := a.Repository.watch(ctx)
+	if err != nil {
+		cancel()
+		return response(nil, http.StatusInternalServerError, err, c)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// the change stream is closed once the client is gone
+		defer cancel()
+
+		// a keep-alive comment lets a failed flush reveal a disconnected client
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Operation, data)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// travelFilter() for build a filter from the query params
+func travelFilter(c *fiber.Ctx) (bson.M, error) {
+	filter := bson.M{}
+	if done := c.Query("done"); done != "" {
+		value, err := strconv.ParseBool(done)
+		if err != nil {
+			return nil, errors.New("done must be a boolean")
+		}
+		filter["done"] = value
+	}
+	if q := normalizeName(c.Query("q")); q != "" {
+		pattern := regexp.QuoteMeta(strings.ToLower(q))
+		filter["$or"] = bson.A{
+			bson.M{"name_normalized": primitive.Regex{Pattern: pattern}},
+			// travels written before name_normalized existed are matched on their name
+			bson.M{"name_normalized": bson.M{"$exists": false}, "name": primitive.Regex{Pattern: pattern, Options: "i"}},
+		}
+	}
+	if tag := strings.ToLower(normalizeName(c.Query("tag"))); tag != "" {
+		filter["tags"] = tag
+	}
+	// from (inclusive) and to (exclusive) range over the ObjectID timestamp, as created-between does
+	created := bson.M{}
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, errors.New("from must be an RFC 3339 time")
+		}
+		created["$gte"] = primitive.NewObjectIDFromTimestamp(t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, errors.New("to must be an RFC 3339 time")
+		}
+		created["$lt"] = primitive.NewObjectIDFromTimestamp(t)
+	}
+	if len(created) > 0 {
+		filter["_id"] = created
+	}
+	return filter, nil
+}
+
+// defaultPhoto() for fill an empty photo with DEFAULT_PHOTO_URL on read, the stored travel is untouched
+func defaultPhoto(travel *Travel) {
+	if travel.Photo == "" {
+		travel.Photo = os.Getenv("DEFAULT_PHOTO_URL")
+	}
+}
+
+// getTravel() for create a Travel
+func (a *appService) createTravel(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	if jsonBodyStart(c) == '[' {
+		return response(nil, http.StatusUnprocessableEntity,
+			errors.New("body is an array, create several travels with POST /api/v1/travels/batch"), c)
+	}
+	if err := strictDone(c); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	var travel Travel
+	if err := c.BodyParser(&travel); err != nil {
+		return response(travel, http.StatusUnprocessableEntity, err, c)
+	}
+	travel.normalize()
+	if err := travel.Validate(); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	err := a.Repository.insertOne(ctx, &travel)
+	if err == nil {
+		a.audit(c, "create", travel.ObjectID.Hex(), nil, &travel)
+	}
+	return response(travel, http.StatusOK, err, c)
+}
+
+// maxBatchSize for the most travels a batch create accepts
+const maxBatchSize = 100
+
+// batchItemError for a travel of a batch which was valid but could not be inserted, by its index
+type batchItemError struct {
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
+// batchResult for a batch which was only partly inserted
+type batchResult struct {
+	Inserted Travels          `json:"inserted"`
+	Failed   []batchItemError `json:"failed"`
+}
+
+// createTravels() for create Travels from a JSON array, none of them when one is invalid. When some
+// inserts fail, the others are kept and it answers 207 with what was inserted and what failed.
+func (a *appService) createTravels(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	if jsonBodyStart(c) != '[' {
+		return response(nil, http.StatusUnprocessableEntity,
+			errors.New("body must be an array, create a single travel with POST /api/v1/travels"), c)
+	}
+	var bodies []map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &bodies); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	if len(bodies) == 0 || len(bodies) > maxBatchSize {
+		return response(nil, http.StatusUnprocessableEntity,
+			fmt.Errorf("body must have between 1 and %d travels", maxBatchSize), c)
+	}
+
+	travels := make(Travels, len(bodies))
+	var errs ValidationError
+	for i, body := range bodies {
+		err := checkDone(body)
+		if err == nil {
+			raw, _ := json.Marshal(body)
+			if err = json.Unmarshal(raw, &travels[i]); err == nil {
+				travels[i].normalize()
+				err = travels[i].Validate()
+			}
+		}
+		var validationErr ValidationError
+		switch {
+		case err == nil:
+		case errors.As(err, &validationErr):
+			for _, fieldErr := range validationErr {
+				fieldErr.Field = fmt.Sprintf("[%d].%s", i, fieldErr.Field)
+				errs = append(errs, fieldErr)
+			}
+		default:
+			errs = append(errs, FieldError{Field: fmt.Sprintf("[%d]", i), Rule: "type", Message: err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return response(nil, http.StatusUnprocessableEntity, errs, c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	inserted, err := a.Repository.insertMany(ctx, travels)
+	var bulkErr mongo.BulkWriteException
+	if err != nil && (!errors.As(err, &bulkErr) || len(bulkErr.WriteErrors) == 0) {
+		return response(nil, http.StatusInternalServerError, err, c)
+	}
+	result := batchResult{Inserted: Travels{}, Failed: []batchItemError{}}
+	failed := map[int]bool{}
+	for _, writeErr := range bulkErr.WriteErrors {
+		failed[writeErr.Index] = true
+		result.Failed = append(result.Failed, batchItemError{Index: writeErr.Index, Message: writeErr.Message})
+	}
+	for i := range travels {
+		if failed[i] {
+			continue
+		}
+		a.audit(c, "create", travels[i].ObjectID.Hex(), nil, &travels[i])
+		result.Inserted = append(result.Inserted, travels[i])
+	}
+	if len(result.Failed) > 0 {
+		log.Printf("batch create: %d of %d travels inserted", inserted, len(travels))
+		return response(result, http.StatusMultiStatus, nil, c)
+	}
+	return response(travels, http.StatusOK, nil, c)
+}
+
+// jsonBodyStart() for the first non-whitespace byte of a JSON body, 0 for an empty or non-JSON body
+func jsonBodyStart(c *fiber.Ctx) byte {
+	if !strings.Contains(c.Get(fiber.HeaderContentType), "json") {
+		return 0
+	}
+	if body := bytes.TrimSpace(c.Body()); len(body) > 0 {
+		return body[0]
+	}
+	return 0
+}
+
+// validateTravel() for check a Travel payload with the rules of createTravel(), without saving it
+func (a *appService) validateTravel(c *fiber.Ctx) error {
+	if err := strictDone(c); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	var travel Travel
+	if err := c.BodyParser(&travel); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	travel.normalize()
+	if err := travel.Validate(); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	return response(map[string]bool{"valid": true}, http.StatusOK, nil, c)
+}
+
+// importBatchSize for the number of CSV rows inserted, and reported, at a time
+const importBatchSize = 100
+
+// importProgress for the progress of a CSV import, sent after each batch
+type importProgress struct {
+	Processed int `json:"processed"`
+	Total     int `json:"total"`
+	Errors    int `json:"errors"`
+}
+
+// importRowError for a CSV row which was not imported, rows are numbered from 1 after the header
+type importRowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// importSummary for the result of a CSV import, sent when it is finished
+type importSummary struct {
+	importProgress
+	Inserted int              `json:"inserted"`
+	Failed   []importRowError `json:"failed"`
+}
+
+// importTravels() for create Travels from a CSV, streaming the progress as server-sent events.
+// The CSV is the request body or the file form field, with a header naming the name, description,
+// photo and done columns. Only name is required.
+func (a *appService) importTravels(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	// copied, the request and its form files are reset before the stream writer reads the CSV
+	var body []byte
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			return response(nil, http.StatusBadRequest, errors.New("file is not defined"), c)
+		}
+		f, err := file.Open()
+		if err != nil {
+			return response(nil, http.StatusBadRequest, err, c)
+		}
+		defer f.Close()
+		if body, err = io.ReadAll(f); err != nil {
+			return response(nil, http.StatusBadRequest, err, c)
+		}
+	} else {
+		body = append([]byte(nil), c.Body()...)
+	}
+
+	reader := csvReader(body)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return response(nil, http.StatusBadRequest, errors.New("csv has no header"), c)
+	}
+	if err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return response(nil, http.StatusBadRequest, errors.New("csv header has no name column"), c)
+	}
+	// the rows are counted, not kept: the progress has a total, and a malformed CSV is refused
+	// before anything is inserted
+	total := 0
+	for {
+		if _, err := reader.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			return response(nil, http.StatusBadRequest, err, c)
+		}
+		total++
+	}
+
+	requestCtx := requestContext(c)
+	audit := a.auditor(c)
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithCancel(requestCtx)
+		defer cancel()
+
+		// read again one row at a time, past the header
+		reader := csvReader(body)
+		if _, err := reader.Read(); err != nil {
+			log.Println("import:", err)
+			return
+		}
+		summary := importSummary{importProgress: importProgress{Total: total}, Failed: []importRowError{}}
+		for start := 0; start < total; start += importBatchSize {
+			end := start + importBatchSize
+			if end > total {
+				end = total
+			}
+			var batch Travels
+			for i := start; i < end; i++ {
+				row, err := reader.Read()
+				if err != nil {
+					log.Println("import:", err)
+					return
+				}
+				travel, err := travelFromCSV(row, columns)
+				if err != nil {
+					summary.Failed = append(summary.Failed, importRowError{Row: i + 1, Message: err.Error()})
+					continue
+				}
+				batch = append(batch, *travel)
+			}
+
+			batchCtx, batchCancel := context.WithTimeout(ctx, 20*time.Second)
+			inserted, err := a.Repository.insertMany(batchCtx, batch)
+			batchCancel()
+			summary.Inserted += inserted
+			failed := map[int]bool{}
+			var bulkErr mongo.BulkWriteException
+			if errors.As(err, &bulkErr) {
+				for _, writeErr := range bulkErr.WriteErrors {
+					failed[writeErr.Index] = true
+				}
+			}
+			// without write errors, a failed insert inserted nothing
+			if err == nil || len(failed) > 0 {
+				for i := range batch {
+					if !failed[i] {
+						audit("create", batch[i].ObjectID.Hex(), nil, &batch[i])
+					}
+				}
+			}
+			if err != nil {
+				log.Println("import:", err)
+				summary.Failed = append(summary.Failed, importRowError{
+					Row:     start + 1,
+					Message: fmt.Sprintf("rows %d to %d: %d of %d not inserted", start+1, end, len(batch)-inserted, len(batch)),
+				})
+			}
+			summary.Processed = end
+			summary.Errors = len(summary.Failed)
+
+			if !writeEvent(w, "progress", summary.importProgress) {
+				return
+			}
+		}
+		writeEvent(w, "summary", summary)
+	})
+	return nil
+}
+
+// csvReader() for a reader of the rows of an imported CSV, one at a time
+func csvReader(body []byte) *csv.Reader {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+	return reader
+}
+
+// travelFromCSV() for the Travel of a CSV row, normalized and validated
+func travelFromCSV(row []string, columns map[string]int) (*Travel, error) {
+	value := func(column string) string {
+		if i, ok := columns[column]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	travel := Travel{
+		Name:        value("name"),
+		Description: value("description"),
+		Photo:       strings.TrimSpace(value("photo")),
+	}
+	if done := strings.TrimSpace(value("done")); done != "" {
+		parsed, err := strconv.ParseBool(done)
+		if err != nil {
+			return nil, errors.New("done must be a boolean")
+		}
+		travel.Done = parsed
+	}
+	travel.normalize()
+	if err := travel.Validate(); err != nil {
+		return nil, err
+	}
+	return &travel, nil
+}
+
+// writeEvent() for write and flush a server-sent event, it returns false once the client is gone
+func writeEvent(w *bufio.Writer, event string, data interface{}) bool {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Println(err)
+		return true
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return w.Flush() == nil
+}
+
+// updateTravel() for update a Travel
+func (a *appService) updateTravel(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	id := c.Params("id")
+	log.Println(id)
+	if id == "" {
+		return response(nil, http.StatusUnprocessableEntity, errors.New("id is not defined"), c)
+	}
+	if err := strictDone(c); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	var travel Travel
+	if err := c.BodyParser(&travel); err != nil {
+		return response(travel, http.StatusUnprocessableEntity, err, c)
+	}
+	travel.normalize()
+	if err := travel.Validate(); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+
+	version, ok := ifMatch(c)
+	if !ok {
+		return response(nil, http.StatusPreconditionFailed, errPreconditionFailed, c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	before, _ := a.Repository.findOne(ctx, id)
+
+	// return=representation answers with the updated Travel, return=minimal (default) with no content
+	switch c.Query("return", "minimal") {
+	case "representation":
+		updated, err := a.Repository.updateOneAndReturn(ctx, id, &travel, version)
+		if err == nil {
+			c.Set(fiber.HeaderETag, travelETag(updated))
+			a.audit(c, "update", id, before, updated)
+		}
+		return response(updated, http.StatusOK, err, c)
+	case "minimal":
+		err := a.Repository.updateOne(ctx, id, &travel, version)
+		if err == nil {
+			a.audit(c, "update", id, before, &travel)
+		}
+		return response(nil, http.StatusNoContent, err, c)
+	default:
+		return response(nil, http.StatusBadRequest, errors.New("return must be representation or minimal"), c)
+	}
+}
+
+// mimeMergePatch for a JSON merge patch body, see RFC 7386
+const mimeMergePatch = "application/merge-patch+json"
+
+// patchableFields for the fields a PATCH may change
+var patchableFields = map[string]bool{"name": true, "description": true, "photo": true, "done": true, "tags": true}
+
+// patchTravel() for update some fields of a Travel. A JSON body sets the given fields, a merge patch
+// body removes the fields which are null.
+func (a *appService) patchTravel(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	id := c.Params("id")
+	if id == "" {
+		return response(nil, http.StatusUnprocessableEntity, errors.New("id is not defined"), c)
+	}
+	mergePatch := strings.HasPrefix(c.Get(fiber.HeaderContentType), mimeMergePatch)
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &fields); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	// done cannot be removed by a merge patch either, a travel is always done or not
+	if err := checkDone(fields); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	version, ok := ifMatch(c)
+	if !ok {
+		return response(nil, http.StatusPreconditionFailed, errPreconditionFailed, c)
+	}
+	var unknown ValidationError
+	for field := range fields {
+		if !patchableFields[field] {
+			unknown = append(unknown, newFieldError(field, "patchable"))
+		}
+	}
+	if len(unknown) > 0 {
+		return response(nil, http.StatusUnprocessableEntity, unknown, c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	travel, err := a.Repository.findOne(ctx, id)
+	if err != nil {
+		return response(nil, http.StatusOK, err, c)
+	}
+	if version != nil && !version.Equal(travel.UpdatedAt) {
+		return response(nil, http.StatusPreconditionFailed, errPreconditionFailed, c)
+	}
+	before := *travel
+
+	// apply the patch to the stored travel, so the result is validated as a whole
+	values := map[string]json.RawMessage{}
+	var unset []string
+	for field, value := range fields {
+		if mergePatch && string(bytes.TrimSpace(value)) == "null" {
+			unset = append(unset, field)
+			clearField(travel, field)
+			continue
+		}
+		values[field] = value
+	}
+	patch, _ := json.Marshal(values)
+	if err := json.Unmarshal(patch, travel); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	travel.normalize()
+	if err := travel.Validate(); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+
+	patched := map[string]interface{}{
+		"name":        travel.Name,
+		"description": travel.Description,
+		"photo":       travel.Photo,
+		"done":        travel.Done,
+		"tags":        travel.Tags,
+	}
+	set := bson.M{}
+	for field := range values {
+		set[field] = patched[field]
+		switch field {
+		case "name":
+			set["name_normalized"] = travel.NameNormalized
+		case "done":
+			set["status"] = travel.Status
+		}
+	}
+
+	err = a.Repository.patchOne(ctx, id, set, unset, version)
+	if err == nil {
+		a.audit(c, "patch", id, &before, travel)
+	}
+	return response(nil, http.StatusNoContent, err, c)
+}
+
+// clearField() for reset a patchable field of a Travel to its zero value
+func clearField(travel *Travel, field string) {
+	switch field {
+	case "name":
+		travel.Name = ""
+	case "description":
+		travel.Description = ""
+	case "photo":
+		travel.Photo = ""
+	case "done":
+		travel.Done = false
+	case "tags":
+		travel.Tags = nil
+	}
+}
+
+// deleteTravel() for delete a travel
+func (a *appService) deleteTravel(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	id := c.Params("id")
+	log.Println(id)
+	if id == "" {
+		return response(nil, http.StatusUnprocessableEntity, errors.New("id is not defined"), c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	before, err := a.Repository.deleteOne(ctx, id, unmodifiedSince(c))
+	// a travel which did not exist was not deleted
+	if err == nil && before != nil {
+		a.audit(c, "delete", id, before, nil)
+	}
+	return response(nil, http.StatusNoContent, err, c)
+}
+
+// unmodifiedSince() for the If-Unmodified-Since date of a request, nil when there is none or it does
+// not parse
+func unmodifiedSince(c *fiber.Ctx) *time.Time {
+	since, err := http.ParseTime(c.Get(fiber.HeaderIfUnmodifiedSince))
+	if err != nil {
+		return nil
+	}
+	return &since
+}
+
+// reorderTravels() for reorder every Travel from an ordered list of ids
+func (a *appService) reorderTravels(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	err := a.Repository.reorder(ctx, body.IDs)
+	if errors.Is(err, errReorderMismatch) {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	return response(nil, http.StatusNoContent, err, c)
+}
+
+// strictDone() for reject a JSON done value which is not a boolean, e.g. "true" or 1
+func strictDone(c *fiber.Ctx) error {
+	if !strings.Contains(c.Get(fiber.HeaderContentType), "json") {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &fields); err != nil {
+		// not an object, BodyParser reports it
+		return nil
+	}
+	return checkDone(fields)
+}
+
+// checkDone() for reject a done field of a JSON object which is not a boolean
+func checkDone(fields map[string]json.RawMessage) error {
+	done, ok := fields["done"]
+	if !ok {
+		return nil
+	}
+	if value := string(bytes.TrimSpace(done)); value != "true" && value != "false" {
+		return ValidationError{newFieldError("done", "boolean")}
+	}
+	return nil
+}
+
+// bulkDoneTravels() for set done of every Travel matching the list filter query params. Archived
+// travels are left alone unless includeArchived=true, like the list, and without a filter all=true
+// must confirm every travel is meant.
+func (a *appService) bulkDoneTravels(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	filter, err := travelFilter(c)
+	if err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	if len(filter) == 0 && c.Query("all") != "true" {
+		return response(nil, http.StatusBadRequest,
+			errors.New("a filter is required, or all=true to change every travel"), c)
+	}
+	if c.Query("includeArchived") != "true" {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+	if err := strictDone(c); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	var body struct {
+		Done *bool `json:"done"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	if body.Done == nil {
+		return response(nil, http.StatusUnprocessableEntity,
+			ValidationError{newFieldError("done", "required")}, c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	modified, err := a.Repository.updateMany(ctx, filter, bson.M{"done": *body.Done, "status": statusOf(*body.Done)})
+	return response(map[string]int64{"modified": modified}, http.StatusOK, err, c)
+}
+
+// assignTravelTags() for add, set or remove tags on many Travels at once
+func (a *appService) assignTravelTags(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	var body struct {
+		IDs  []string `json:"ids"`
+		Tags []string `json:"tags"`
+		Mode string   `json:"mode"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+
+	var errs ValidationError
+	if len(body.IDs) == 0 {
+		errs = append(errs, newFieldError("ids", "required"))
+	} else if len(body.IDs) > maxBatchSize {
+		errs = append(errs, newFieldError("ids", "max_items", maxBatchSize))
+	}
+	ids := make([]primitive.ObjectID, 0, len(body.IDs))
+	for i, id := range body.IDs {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			errs = append(errs, newFieldError(fmt.Sprintf("ids[%d]", i), "objectid"))
+			continue
+		}
+		ids = append(ids, objectID)
+	}
+	tags := normalizeTags(body.Tags)
+	if tags == nil {
+		// set with no tags clears them, an array rather than null
+		tags = []string{}
+	}
+	for i, tag := range tags {
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			errs = append(errs, newFieldError(fmt.Sprintf("tags[%d]", i), "max", maxTagLength))
+		}
+	}
+	if !isTagMode(body.Mode) {
+		errs = append(errs, newFieldError("mode", "oneof", strings.Join(tagModes, ", ")))
+	} else if len(tags) == 0 && body.Mode != "set" {
+		errs = append(errs, newFieldError("tags", "required"))
+	} else if len(tags) > maxTagsPerTravel() && body.Mode != "remove" {
+		errs = append(errs, newFieldError("tags", "max_items", maxTagsPerTravel()))
+	}
+	if len(errs) > 0 {
+		return response(nil, http.StatusUnprocessableEntity, errs, c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	if body.Mode == "add" {
+		// checked first to answer 422, the update skips such travels as well
+		over, err := a.Repository.count(ctx, bson.M{"_id": bson.M{"$in": ids}, "$expr": bson.M{"$not": bson.A{tagsFit(tags)}}})
+		if err != nil {
+			return response(nil, http.StatusInternalServerError, err, c)
+		}
+		if over > 0 {
+			return response(nil, http.StatusUnprocessableEntity,
+				ValidationError{newFieldError("tags", "max_items", maxTagsPerTravel())}, c)
+		}
+	}
+
+	modified, err := a.Repository.assignTags(ctx, ids, tags, body.Mode)
+	return response(map[string]int64{"modified": modified}, http.StatusOK, err, c)
+}
+
+// mergeTravel() for merge a duplicate Travel, the source, into the Travel of the id: it gets the tags
+// of the source, which is archived
+func (a *appService) mergeTravel(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	id := c.Params("id")
+	var body struct {
+		SourceID string `json:"sourceId"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	if body.SourceID == "" {
+		return response(nil, http.StatusUnprocessableEntity, ValidationError{newFieldError("sourceId", "required")}, c)
+	}
+	if body.SourceID == id {
+		return response(nil, http.StatusUnprocessableEntity, errors.New("sourceId must differ from the id"), c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	before, merged, err := a.Repository.merge(ctx, id, body.SourceID)
+	if err != nil {
+		return response(nil, http.StatusInternalServerError, err, c)
+	}
+	a.audit(c, "merge", id, before, merged)
+	a.audit(c, "archive", body.SourceID, nil, nil)
+	return response(merged, http.StatusOK, nil, c)
+}
+
+// viewTravel() for count a view of a Travel and answer the new count. With async=true the count is
+// written after answering 202, for clients which do not wait for it.
+func (a *appService) viewTravel(c *fiber.Ctx) error {
+	id := utils.CopyString(c.Params("id"))
+	if c.Query("async") == "true" {
+		ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
+		go func() {
+			defer cancel()
+			if _, err := a.Repository.incrementViews(ctx, id); err != nil {
+				log.Printf("level=warn msg=\"view not counted\" id=%s error=%v", id, err)
+			}
+		}()
+		return response(nil, http.StatusAccepted, nil, c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	views, err := a.Repository.incrementViews(ctx, id)
+	return response(map[string]int64{"views": views}, http.StatusOK, err, c)
+}
+
+// archiveTravel() for hide a Travel from the list without deleting it
+func (a *appService) archiveTravel(c *fiber.Ctx) error {
+	return a.setArchived(c, true)
+}
+
+// unarchiveTravel() for show an archived Travel in the list again
+func (a *appService) unarchiveTravel(c *fiber.Ctx) error {
+	return a.setArchived(c, false)
+}
+
+// setArchived() for set archived of a Travel
+func (a *appService) setArchived(c *fiber.Ctx, archived bool) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	id := c.Params("id")
+	if id == "" {
+		return response(nil, http.StatusUnprocessableEntity, errors.New("id is not defined"), c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	err := a.Repository.updateField(ctx, id, "archived", archived)
+	if err == nil {
+		action := "unarchive"
+		if archived {
+			action = "archive"
+		}
+		a.audit(c, action, id, nil, nil)
+	}
+	return response(nil, http.StatusNoContent, err, c)
+}
+
+// lockTTL() for how long a lock for editing is held without being renewed, LOCK_TTL_SECONDS or 5 minutes
+func lockTTL() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("LOCK_TTL_SECONDS"))
+	if err != nil || seconds <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Second * time.Duration(seconds)
+}
+
+// lockedByOther() for errTravelLocked when another actor holds a lock on the travel which has not expired
+func lockedByOther(travel *Travel, actor string) error {
+	if travel == nil || travel.LockedBy == "" || travel.LockedBy == actor || travel.LockedAt == nil {
+		return nil
+	}
+	if clock().Sub(*travel.LockedAt) > lockTTL() {
+		return nil
+	}
+	return errTravelLocked
+}
+
+// lockTravel() for lock a Travel for editing, or renew the lock, so other users cannot change it
+func (a *appService) lockTravel(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	id := c.Params("id")
+	if id == "" {
+		return response(nil, http.StatusUnprocessableEntity, errors.New("id is not defined"), c)
+	}
+
+	lockedBy := actor(c)
+	// without a sub every caller is the same anonymous actor, and would share the lock
+	if lockedBy == anonymousActor {
+		return response(nil, http.StatusForbidden, errAnonymousLock, c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	ttl := lockTTL()
+	lockedAt, err := a.Repository.lock(ctx, id, lockedBy, ttl)
+	if err != nil {
+		return response(nil, http.StatusInternalServerError, err, c)
+	}
+	a.audit(c, "lock", id, nil, nil)
+	return response(map[string]interface{}{
+		"locked_by":  lockedBy,
+		"locked_at":  lockedAt,
+		"expires_at": lockedAt.Add(ttl),
+	}, http.StatusOK, nil, c)
+}
+
+// unlockTravel() for release the lock for editing of a Travel
+func (a *appService) unlockTravel(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
+
+	id := c.Params("id")
+	if id == "" {
+		return response(nil, http.StatusUnprocessableEntity, errors.New("id is not defined"), c)
+	}
+
+	if actor(c) == anonymousActor {
+		return response(nil, http.StatusForbidden, errAnonymousLock, c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	err := a.Repository.unlock(ctx, id, actor(c), lockTTL())
+	if err == nil {
+		a.audit(c, "unlock", id, nil, nil)
+	}
+	return response(nil, http.StatusNoContent, err, c)
+}
+
+// audit() for record a successful write in the background. It never delays or fails the request,
+// a failed audit write is only logged.
+func (a *appService) audit(c *fiber.Ctx, action, id string, before, after *Travel) {
+	a.auditor(c)(action, id, before, after)
+}
+
+// auditor() for the audit() of a request, which may still be called once the handler returned, from a
+// stream writer
+func (a *appService) auditor(c *fiber.Ctx) func(action, id string, before, after *Travel) {
+	actor, ip, ctx := actor(c), utils.CopyString(c.IP()), requestContext(c)
+	return func(action, id string, before, after *Travel) {
+		a.writeAudit(ctx, &AuditRecord{
+			Actor:    actor,
+			IP:       ip,
+			Action:   action,
+			TravelID: utils.CopyString(id),
+			At:       now(),
+			Before:   summarize(before),
+			After:    summarize(after),
+		})
+	}
+}
+
+// writeAudit() for insert an audit record in the background
+func (a *appService) writeAudit(ctx context.Context, record *AuditRecord) {
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		if err := a.Repository.insertAudit(ctx, record); err != nil {
+			log.Printf("level=error msg=\"audit write failed\" action=%s travel=%s actor=%s error=%v",
+				record.Action, record.TravelID, record.Actor, err)
+		}
+	}()
+}
+
+// anonymousActor for the actor of a request without a token, or with a token without a sub claim
+const anonymousActor = "anonymous"
+
+// actor() for who made a request, the sub claim of its JWT or anonymousActor for a token without one
+func actor(c *fiber.Ctx) string {
+	if token, ok := c.Locals("jwt").(*jwt.Token); ok {
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			if sub, ok := claims["sub"].(string); ok && sub != "" {
+				return sub
+			}
+		}
+	}
+	return anonymousActor
+}
+
+// getPhotoHosts() for get the distinct hosts the stored photos are served from, sorted
+func (a *appService) getPhotoHosts(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	photos, err := a.Repository.distinctPhotos(ctx)
+	if err != nil {
+		return response(nil, http.StatusInternalServerError, err, c)
+	}
+	seen := map[string]bool{}
+	hosts := []string{}
+	for _, photo := range photos {
+		u, err := url.Parse(photo)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		if host := strings.ToLower(u.Hostname()); !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return response(map[string][]string{"hosts": hosts}, http.StatusOK, nil, c)
+}
+
+// renameTravels() for replace a part of the name of every Travel containing it, e.g. Saigon with
+// Ho Chi Minh City. dryRun only counts the Travels which would be renamed.
+func (a *appService) renameTravels(c *fiber.Ctx) error {
+	var body struct {
+		Find    string `json:"find"`
+		Replace string `json:"replace"`
+		DryRun  bool   `json:"dryRun"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return response(nil, http.StatusUnprocessableEntity, err, c)
+	}
+	var errs ValidationError
+	if body.Find == "" {
+		errs = append(errs, newFieldError("find", "required"))
+	}
+	if strings.TrimSpace(body.Replace) == "" {
+		errs = append(errs, newFieldError("replace", "required"))
+	}
+	if len(errs) > 0 {
+		return response(nil, http.StatusUnprocessableEntity, errs, c)
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	if body.DryRun {
+		matched, err := a.Repository.count(ctx, renameFilter(body.Find))
+		return response(map[string]interface{}{"matched": matched, "dryRun": true}, http.StatusOK, err, c)
+	}
+	modified, err := a.Repository.renameMany(ctx, body.Find, body.Replace)
+	return response(map[string]interface{}{"modified": modified, "dryRun": false}, http.StatusOK, err, c)
+}
+
+// migrateTravels() for fill in the fields of backfills on the Travels written before they existed.
+// Running it again changes nothing, and dryRun only counts the Travels lacking each field.
+func (a *appService) migrateTravels(c *fiber.Ctx) error {
+	var body struct {
+		DryRun bool `json:"dryRun"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return response(nil, http.StatusUnprocessableEntity, err, c)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Minute)
+	defer cancel()
+
+	fixed := map[string]int64{}
+	for _, b := range backfills {
+		var n int64
+		var err error
+		switch {
+		case body.DryRun:
+			n, err = a.Repository.count(ctx, backfillFilter(b.field))
+		case b.derive != nil:
+			n, err = a.Repository.backfillEach(ctx, b.field, b.derive)
+		default:
+			n, err = a.Repository.backfill(ctx, b.field, b.value)
+		}
+		if err != nil {
+			return response(nil, http.StatusInternalServerError, err, c)
+		}
+		fixed[b.field] = n
+	}
+	return response(map[string]interface{}{"fixed": fixed, "dryRun": body.DryRun}, http.StatusOK, nil, c)
 }
 
-// Service for Travel service interfaces
-type Service interface {
-	getTravels(c *fiber.Ctx) error
-	getTravel(c *fiber.Ctx) error
-	createTravel(c *fiber.Ctx) error
-	updateTravel(c *fiber.Ctx) error
-	deleteTravel(c *fiber.Ctx) error
+// activityPageSize for the number of audit records of a page without a limit
+const activityPageSize = 50
+
+// getUserActivity() for get the audit records of a user, newest first. A user without any record is
+// unknown, there is no user store to tell it from one who never wrote.
+func (a *appService) getUserActivity(c *fiber.Ctx) error {
+	limit, offset, err := parsePage(c)
+	if err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	if limit == 0 {
+		limit = activityPageSize
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	records, total, err := a.Repository.findAudit(ctx, bson.M{"actor": c.Params("userId")}, limit, offset)
+	if err != nil {
+		return response(nil, http.StatusInternalServerError, err, c)
+	}
+	if total == 0 {
+		return response(nil, http.StatusNotFound, errors.New("user has no activity"), c)
+	}
+	return response(map[string]interface{}{"total": total, "activity": records}, http.StatusOK, nil, c)
 }
 
-// NewService for initialize service
-func NewService(r Repository) Service {
-	return &appService{Repository: r}
+// getSimilarTravels() for get the Travels sharing the most tags with a Travel, photos left out like a list
+func (a *appService) getSimilarTravels(c *fiber.Ctx) error {
+	limit, err := strconv.ParseInt(c.Query("limit", "5"), 10, 64)
+	if err != nil || limit < 1 || limit > 50 {
+		return response(nil, http.StatusBadRequest, errors.New("limit must be a number from 1 to 50"), c)
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
+	defer cancel()
+
+	travel, err := a.Repository.findOne(ctx, c.Params("id"))
+	if err != nil {
+		return response(nil, http.StatusNotFound, notFound(err), c)
+	}
+	similar, err := a.Repository.similar(ctx, travel, limit)
+	return response(similar, http.StatusOK, err, c)
 }
 
-// getTravels() for get Travels
-func (a *appService) getTravels(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+// getTravelHistory() for get the audited changes of a Travel, newest first, an empty list when there are none.
+// It names the actors, so it needs a token.
+func (a *appService) getTravelHistory(c *fiber.Ctx) error {
+	if status, err := checkToken(c); err != nil {
+		return response(nil, status, err, c)
+	}
 
+	limit, offset, err := parsePage(c)
+	if err != nil {
+		return response(nil, http.StatusBadRequest, err, c)
+	}
+	if limit == 0 {
+		limit = activityPageSize
+	}
+	ctx, cancel := context.WithTimeout(requestContext(c), 20*time.Second)
 	defer cancel()
 
-	travels, err := a.Repository.findAll(ctx)
-	return response(travels, http.StatusOK, err, c)
+	records, total, err := a.Repository.findAudit(ctx, bson.M{"travel_id": c.Params("id")}, limit, offset)
+	if err != nil {
+		return response(nil, http.StatusInternalServerError, err, c)
+	}
+	for i := range *records {
+		record := &(*records)[i]
+		record.Changes = changes(record.Before, record.After)
+	}
+	return response(map[string]interface{}{"total": total, "history": records}, http.StatusOK, nil, c)
+}
+
+// checkToken() for check the JWT of a private route, it returns the status to answer with on failure
+func checkToken(c *fiber.Ctx) (int, error) {
+	// API-key callers were already checked by JWTProtected and carry no JWT to verify
+	if isService(c) {
+		return 0, nil
+	}
+	now := time.Now().Unix()
+
+	// Get claims from JWT.
+	claims, err := ExtractTokenMetadata(c)
+	if err != nil {
+		// Return status 500 and JWT parse error.
+		return fiber.StatusInternalServerError, err
+	}
+
+	// Checking, if now time greater than expiration from JWT.
+	if now > claims.Expires {
+		// Return status 401 and unauthorized error message.
+		return fiber.StatusUnauthorized, errors.New("unauthorized, check expiration time of your token")
+	}
+	return 0, nil
+}
+
+// response to route
+func response(data interface{}, httpStatus int, err error, c *fiber.Ctx) error {
+	if err != nil {
+		locale := requestLocale(c)
+		// Validate() missed a rule of the collection validator, e.g. MAX_NAME_LENGTH differs between
+		// instances. This driver does not report which rule failed.
+		if isDocumentInvalid(err) {
+			err = errDocumentInvalid
+			httpStatus = http.StatusUnprocessableEntity
+		}
+		// a body which is not JSON at all is a bad request, a JSON body breaking the rules is unprocessable
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			msg := message(locale, "malformed_json")
+			if offset := syntaxErrorOffset(c.Body()); offset > 0 {
+				msg = message(locale, "malformed_json_at", offset)
+			}
+			return c.Status(http.StatusBadRequest).JSON(map[string]string{"error": msg})
+		}
+		var validationErr ValidationError
+		if errors.As(err, &validationErr) {
+			return c.Status(http.StatusUnprocessableEntity).JSON(map[string]interface{}{
+				"errors": validationErr.localize(locale),
+			})
+		}
+		if errors.Is(err, errTravelNotFound) || errors.Is(err, mongo.ErrNoDocuments) {
+			httpStatus = http.StatusNotFound
+		}
+		if errors.Is(err, errTravelConflict) {
+			httpStatus = http.StatusConflict
+		}
+		if errors.Is(err, errPreconditionFailed) {
+			httpStatus = http.StatusPreconditionFailed
+		}
+		if errors.Is(err, errTravelLocked) {
+			httpStatus = http.StatusLocked
+		}
+		// success status codes are replaced, the error is unexpected
+		if httpStatus < http.StatusBadRequest {
+			httpStatus = http.StatusInternalServerError
+		}
+		return c.Status(httpStatus).JSON(map[string]string{
+			"error": localizeError(locale, err),
+		})
+	} else {
+		if data != nil {
+			return serialize(data, httpStatus, c)
+		} else {
+			c.Status(httpStatus)
+			return nil
+		}
+	}
+}
+
+// syntaxErrorOffset() for the byte offset of the first syntax error of a JSON body, 0 when unknown.
+// The decoder of BodyParser does not report it, encoding/json does.
+func syntaxErrorOffset(body []byte) int64 {
+	var value interface{}
+	var syntaxErr *json.SyntaxError
+	if err := json.Unmarshal(body, &value); errors.As(err, &syntaxErr) {
+		return syntaxErr.Offset
+	}
+	return 0
+}
+
+// defaultLocale for the locale of the messages without an Accept-Language or DEFAULT_LOCALE
+const defaultLocale = "en"
+
+// messages for the error and validation messages by locale and code. A validation code is the rule,
+// its message is formatted with the field and the params of the rule.
+var messages = map[string]map[string]string{
+	"en": {
+		"required":            "%[1]s is required",
+		"max":                 "%[1]s must be at most %[2]v characters",
+		"url":                 "%[1]s must be a valid http(s) URL",
+		"host":                "%[1]s host must be one of %[2]v",
+		"patchable":           "%[1]s cannot be patched",
+		"boolean":             "%[1]s must be a JSON boolean, true or false",
+		"unknown":             "unknown query param %[1]s",
+		"malformed_json":      "malformed JSON body",
+		"malformed_json_at":   "malformed JSON body at offset %[1]d",
+		"max_items":           "%[1]s must have at most %[2]v items",
+		"objectid":            "%[1]s must be a valid id",
+		"oneof":               "%[1]s must be one of %[2]v",
+		"not_found":           errTravelNotFound.Error(),
+		"conflict":            errTravelConflict.Error(),
+		"precondition_failed": errPreconditionFailed.Error(),
+		"locked":              errTravelLocked.Error(),
+		"anonymous_lock":      errAnonymousLock.Error(),
+		"document_invalid":    errDocumentInvalid.Error(),
+		"reorder_mismatch":    errReorderMismatch.Error(),
+	},
+	"id": {
+		"required":            "%[1]s wajib diisi",
+		"max":                 "%[1]s maksimal %[2]v karakter",
+		"url":                 "%[1]s harus berupa URL http(s) yang valid",
+		"host":                "host %[1]s harus salah satu dari %[2]v",
+		"patchable":           "%[1]s tidak dapat diubah dengan patch",
+		"boolean":             "%[1]s harus berupa boolean JSON, true atau false",
+		"unknown":             "parameter query %[1]s tidak dikenal",
+		"malformed_json":      "body JSON tidak valid",
+		"malformed_json_at":   "body JSON tidak valid pada offset %[1]d",
+		"max_items":           "%[1]s maksimal berisi %[2]v item",
+		"objectid":            "%[1]s harus berupa id yang valid",
+		"oneof":               "%[1]s harus salah satu dari %[2]v",
+		"not_found":           "travel tidak ditemukan",
+		"conflict":            "travel dengan id ini sudah ada",
+		"precondition_failed": "travel telah diubah, ambil lagi untuk mendapatkan ETag terbaru",
+		"locked":              "travel sedang dikunci untuk diedit oleh pengguna lain",
+		"anonymous_lock":      "penguncian memerlukan token dengan klaim sub yang menyatakan penggunanya",
+		"document_invalid":    "travel ditolak oleh validator koleksi",
+		"reorder_mismatch":    "ids harus memuat setiap travel tepat satu kali",
+	},
+}
+
+// errorCodes for the message codes of the errors which have a localized message
+var errorCodes = map[error]string{
+	errTravelNotFound:     "not_found",
+	mongo.ErrNoDocuments:  "not_found",
+	errTravelConflict:     "conflict",
+	errPreconditionFailed: "precondition_failed",
+	errTravelLocked:       "locked",
+	errAnonymousLock:      "anonymous_lock",
+	errDocumentInvalid:    "document_invalid",
+	errReorderMismatch:    "reorder_mismatch",
+}
+
+// message() for the message of a code in a locale, falling back to English
+func message(locale, code string, args ...interface{}) string {
+	format, ok := messages[locale][code]
+	if !ok {
+		format = messages[defaultLocale][code]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// requestLocale() for the locale of the messages of a request, from Accept-Language or DEFAULT_LOCALE
+func requestLocale(c *fiber.Ctx) string {
+	c.Vary(fiber.HeaderAcceptLanguage)
+	fallback := os.Getenv("DEFAULT_LOCALE")
+	if _, ok := messages[fallback]; !ok {
+		fallback = defaultLocale
+	}
+	if c.Get(fiber.HeaderAcceptLanguage) == "" {
+		return fallback
+	}
+	if locale := c.AcceptsLanguages("en", "id"); locale != "" {
+		return locale
+	}
+	return fallback
+}
+
+// localize() for the field errors with their messages in a locale, a rule without a catalog entry keeps its message
+func (v ValidationError) localize(locale string) ValidationError {
+	localized := make(ValidationError, len(v))
+	for i, fieldErr := range v {
+		if fieldErr.args != nil {
+			fieldErr.Message = message(locale, fieldErr.Rule, fieldErr.args...)
+		}
+		localized[i] = fieldErr
+	}
+	return localized
+}
+
+// localizeError() for the message of an error in a locale, errors without a code keep their message
+func localizeError(locale string, err error) string {
+	for target, code := range errorCodes {
+		if errors.Is(err, target) {
+			return message(locale, code)
+		}
+	}
+	return err.Error()
+}
+
+// mediaTypeV2 for the v2 response shape, negotiated with the Accept header
+const mediaTypeV2 = "application/vnd.travelingo.v2+json"
+
+// apiVersion() for the response shape version asked in the Accept header, v1 by default
+func apiVersion(c *fiber.Ctx) int {
+	if strings.Contains(c.Get(fiber.HeaderAccept), mediaTypeV2) {
+		return 2
+	}
+	return 1
+}
+
+// serialize() for write data in the negotiated shape, v1 is the bare resource and v2 wraps it in data.
+// A page is {data, total} in both.
+func serialize(data interface{}, httpStatus int, c *fiber.Ctx) error {
+	c.Vary(fiber.HeaderAccept)
+	if stale, ok := c.Locals("stale").(*bool); ok && *stale {
+		c.Set("X-Served-Stale", "true")
+	}
+	if apiVersion(c) == 2 {
+		body := interface{}(map[string]interface{}{"data": data})
+		if _, ok := data.(travelPage); ok {
+			body = data
+		}
+		if err := c.Status(httpStatus).JSON(body); err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, mediaTypeV2)
+		return nil
+	}
+	return c.Status(httpStatus).JSON(data)
+}
+
+// Routes for endpoint to access handler
+func Routes(app *fiber.App, service Service, monitor *DBMonitor) {
+	api := app.Group("/api/v1")
+
+	api.Get("/health", func(c *fiber.Ctx) error {
+		body := map[string]interface{}{
+			"health": "ok",
+			"status": http.StatusOK,
+			"mode":   maintenanceMode(),
+		}
+		// the database state is reported, not answered with, a liveness probe must not restart the app
+		// for a database outage
+		if monitor != nil {
+			body["database"] = "up"
+			if !monitor.Healthy() {
+				body["health"] = "degraded"
+				body["database"] = "down"
+			}
+		}
+		return c.Status(http.StatusOK).JSON(body)
+	})
+
+	// readiness probe, /health stays the liveness probe
+	api.Get("/ready", service.ready)
+
+	// public endpoint
+	api.Get("/token/new", GetNewAccessToken)
+	api.Use("/travels", TenantResolver())
+	api.Get("/travels", StrictQuery(listParams...), ListETag(), service.getTravels)
+	api.Get("/travels/schema", GetTravelSchema)
+	api.Get("/travels/random", StrictQuery(filterParams...), service.getRandomTravel)
+	api.Get("/travels/next", service.getNextTravel)
+	api.Get("/travels/textsearch", StrictQuery("q", "limit", "offset", "includeArchived"), service.textSearchTravels)
+	// the stats and the export share the stricter limit of the heavy endpoints, per client IP
+	heavy := HeavyRateLimit()
+	api.Get("/travels/tags/counts", heavy, StrictQuery(countParams...), service.countTravelTags)
+	api.Get("/travels/by-month", heavy, StrictQuery(countParams...), service.countTravelsByMonth)
+	api.Get("/travels/streak", heavy, service.getDoneStreak)
+	api.Get("/travels/completion-timeline", heavy, StrictQuery("bucket"), service.getCompletionTimeline)
+	api.Get("/travels/popular", StrictQuery("by", "limit"), service.getPopular)
+	api.Get("/travels/created-between", StrictQuery("from", "to", "includeArchived"), service.getTravelsCreatedBetween)
+	api.Get("/travels/feed.atom", service.getTravelsFeed)
+	// authenticated, unlike the rest of the reads
+	api.Get("/travels/export.jsonl", JWTProtected(), heavy, StrictQuery(countParams...), service.exportTravels)
+	api.Get("/travels/events", service.streamTravelEvents)
+	api.Post("/travels/validate", service.validateTravel)
+	api.Get("/travels/slug/:slug", service.getTravelBySlug)
+	api.Head("/travels/:id", service.headTravel)
+	api.Get("/travels/:id", service.getTravel)
+	api.Get("/travels/:id/similar", StrictQuery("limit"), service.getSimilarTravels)
+	api.Post("/travels/:id/view", StrictQuery("async"), service.viewTravel)
+
+	// private endpoint
+	api.Post("/travels", JWTProtected(), service.createTravel)
+	api.Post("/travels/batch", JWTProtected(), service.createTravels)
+	api.Post("/travels/reorder", JWTProtected(), service.reorderTravels)
+	api.Post("/travels/import", JWTProtected(), service.importTravels)
+	api.Post("/travels/bulk-done", JWTProtected(), service.bulkDoneTravels)
+	api.Post("/travels/tags/assign", JWTProtected(), service.assignTravelTags)
+	api.Put("/travels/:id", JWTProtected(), service.updateTravel)
+	api.Patch("/travels/:id", JWTProtected(), service.patchTravel)
+	api.Delete("/travels/:id", JWTProtected(), service.deleteTravel)
+	api.Post("/travels/:id/archive", JWTProtected(), service.archiveTravel)
+	api.Post("/travels/:id/unarchive", JWTProtected(), service.unarchiveTravel)
+	api.Post("/travels/:id/merge", JWTProtected(), service.mergeTravel)
+	api.Post("/travels/:id/lock", JWTProtected(), service.lockTravel)
+	api.Post("/travels/:id/unlock", JWTProtected(), service.unlockTravel)
+	api.Get("/travels/:id/history", JWTProtected(), StrictQuery("limit", "offset"), service.getTravelHistory)
+
+	// admin endpoint
+	admin := api.Group("/admin", JWTProtected(), AdminOnly())
+	admin.Get("/config", GetConfig)
+	admin.Get("/photo-hosts", service.getPhotoHosts)
+	admin.Post("/travels/rename", service.renameTravels)
+	admin.Post("/migrate", service.migrateTravels)
+	admin.Get("/users/:userId/activity", StrictQuery("limit", "offset"), service.getUserActivity)
+}
+
+// HeavyRateLimit func for throttle the expensive export and stats endpoints, to EXPORT_RATE_LIMIT
+// requests a minute (10 by default, 0 turns it off) per client IP. Not per token: /token/new is public
+// and every token has a subject of its own, a new token would be a new allowance.
+func HeavyRateLimit() func(*fiber.Ctx) error {
+	max := 10
+	if n, err := strconv.Atoi(os.Getenv("EXPORT_RATE_LIMIT")); err == nil && n >= 0 {
+		max = n
+	}
+	if max == 0 {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+	return limiter.New(limiter.Config{
+		Max:        max,
+		Expiration: time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return utils.CopyString(c.IP())
+		},
+		// the limiter has set Retry-After
+		LimitReached: func(c *fiber.Ctx) error {
+			return response(nil, http.StatusTooManyRequests, errors.New("too many requests, retry later"), c)
+		},
+	})
+}
+
+// DBMonitor for the connectivity to the database, pinged in the background so /health does not ping
+// on every request
+type DBMonitor struct {
+	r        Repository
+	interval time.Duration
+	// healthy is 1 while the last ping succeeded
+	healthy int32
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewDBMonitor for a monitor pinging through r every interval, healthy until a ping fails
+func NewDBMonitor(r Repository, interval time.Duration) *DBMonitor {
+	return &DBMonitor{
+		r:        r,
+		interval: interval,
+		healthy:  1,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start() for ping in the background until Stop
+func (m *DBMonitor) Start() {
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				m.check()
+			}
+		}
+	}()
+}
+
+// Stop() for stop pinging, it returns once the monitor is stopped
+func (m *DBMonitor) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+// Healthy() for whether the last ping succeeded
+func (m *DBMonitor) Healthy() bool {
+	return atomic.LoadInt32(&m.healthy) == 1
+}
+
+// check() for ping once and log a change of state
+func (m *DBMonitor) check() {
+	if _, err := m.r.ping(); err != nil {
+		if atomic.SwapInt32(&m.healthy, 0) == 1 {
+			log.Printf("level=warn msg=\"database unreachable\" error=%v", err)
+		}
+	} else if atomic.SwapInt32(&m.healthy, 1) == 0 {
+		log.Printf("level=info msg=\"database reachable again\"")
+	}
+}
+
+// dbHealthcheckInterval() for how often the database is pinged, DB_HEALTHCHECK_INTERVAL (e.g. 10s).
+// Zero, when it is unset, turns the monitor off.
+func dbHealthcheckInterval() (time.Duration, error) {
+	value := os.Getenv("DB_HEALTHCHECK_INTERVAL")
+	if value == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid DB_HEALTHCHECK_INTERVAL: %w", err)
+	}
+	if interval <= 0 {
+		return 0, errors.New("invalid DB_HEALTHCHECK_INTERVAL: must be positive")
+	}
+	return interval, nil
+}
+
+// maintenanceMode() for report the current maintenance mode
+func maintenanceMode() string {
+	if IsReadOnly() {
+		return "read-only"
+	}
+	return "read-write"
+}
+
+// MaintenanceMode func for reject writes while the app is in read-only maintenance mode.
+func MaintenanceMode() func(*fiber.Ctx) error {
+	retryAfter := os.Getenv("MAINTENANCE_RETRY_AFTER")
+	if retryAfter == "" {
+		retryAfter = "120"
+	}
+
+	return func(c *fiber.Ctx) error {
+		if IsReadOnly() && isWrite(c) {
+			c.Set(fiber.HeaderRetryAfter, retryAfter)
+			return c.Status(http.StatusServiceUnavailable).JSON(map[string]string{
+				"error": "service is in read-only maintenance mode",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// isWrite() for check a request may change travels
+func isWrite(c *fiber.Ctx) bool {
+	// validating a payload writes nothing, and a view is a counter, not a change of the travel
+	if strings.HasSuffix(c.Path(), "/travels/validate") || strings.HasSuffix(c.Path(), "/view") {
+		return false
+	}
+	switch c.Method() {
+	case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// ReplicaLagGate for reject writes while the secondaries lag more than MAX_REPLICA_LAG_SECONDS behind
+// the primary, so writes do not pile up. The lag is checked in the background every
+// REPLICA_LAG_CHECK_SECONDS (10 by default), reads are always served.
+type ReplicaLagGate struct {
+	r        Repository
+	maxLag   time.Duration
+	interval time.Duration
+	// lagging is 1 while the last check measured more than maxLag
+	lagging int32
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewReplicaLagGate for a gate checking the lag through r, nil while MAX_REPLICA_LAG_SECONDS is unset
+func NewReplicaLagGate(r Repository) *ReplicaLagGate {
+	maxLagSeconds, _ := strconv.Atoi(os.Getenv("MAX_REPLICA_LAG_SECONDS"))
+	if maxLagSeconds <= 0 {
+		return nil
+	}
+	intervalSeconds, err := strconv.Atoi(os.Getenv("REPLICA_LAG_CHECK_SECONDS"))
+	if err != nil || intervalSeconds <= 0 {
+		intervalSeconds = 10
+	}
+	return &ReplicaLagGate{
+		r:        r,
+		maxLag:   time.Second * time.Duration(maxLagSeconds),
+		interval: time.Second * time.Duration(intervalSeconds),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start() for check the lag now and then in the background until Stop
+func (g *ReplicaLagGate) Start() {
+	go func() {
+		defer close(g.done)
+		ticker := time.NewTicker(g.interval)
+		defer ticker.Stop()
+		for {
+			g.check()
+			select {
+			case <-g.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop() for stop checking, it returns once the gate is stopped
+func (g *ReplicaLagGate) Stop() {
+	close(g.stop)
+	<-g.done
+}
+
+// check() for measure the lag once and log a change of state
+func (g *ReplicaLagGate) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), g.interval)
+	defer cancel()
+
+	lag, err := g.r.replicaLag(ctx)
+	if err != nil {
+		// an unknown lag keeps the gate as it was
+		log.Println("replica lag:", err)
+		return
+	}
+	if lag > g.maxLag {
+		if atomic.SwapInt32(&g.lagging, 1) == 0 {
+			log.Printf("level=warn msg=\"replica lag too high, rejecting writes\" lag=%s max=%s", lag, g.maxLag)
+		}
+	} else if atomic.SwapInt32(&g.lagging, 0) == 1 {
+		log.Printf("level=info msg=\"replica lag recovered, accepting writes\" lag=%s", lag)
+	}
+}
+
+// Handler() for the middleware rejecting writes with 503 while the replicas lag
+func (g *ReplicaLagGate) Handler() func(*fiber.Ctx) error {
+	retryAfter := strconv.Itoa(int(g.interval / time.Second))
+	return func(c *fiber.Ctx) error {
+		if atomic.LoadInt32(&g.lagging) == 1 && isWrite(c) {
+			c.Set(fiber.HeaderRetryAfter, retryAfter)
+			return c.Status(http.StatusServiceUnavailable).JSON(map[string]string{
+				"error": "replication is lagging, writes are paused",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// ListETag func for answer a list with a weak ETag hashed from the page, and 304 when If-None-Match has it.
+// The hash covers the media type and content coding too, so v1 and v2 or gzip and identity responses
+// never share an ETag.
+func ListETag() func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+		if c.Response().StatusCode() != http.StatusOK {
+			return nil
+		}
+		hash := sha1.New()
+		hash.Write(c.Response().Header.ContentType())
+		hash.Write([]byte{0})
+		hash.Write(c.Response().Header.Peek(fiber.HeaderContentEncoding))
+		hash.Write([]byte{0})
+		hash.Write(c.Response().Body())
+		etag := fmt.Sprintf(`W/"%x"`, hash.Sum(nil))
+
+		c.Set(fiber.HeaderETag, etag)
+		c.Vary(fiber.HeaderAcceptEncoding)
+		if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+			c.Response().ResetBody()
+			c.Status(http.StatusNotModified)
+		}
+		return nil
+	}
+}
+
+// etagMatches() for check an If-None-Match header lists the ETag, compared weakly as RFC 7232 asks
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// tracerName for the instrumentation name of the spans of the app, and its default service name
+const tracerName = "travelapp"
+
+// setupTracing() for export spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, the exporter reads the other OTEL_EXPORTER_OTLP_* variables
+// and the SDK OTEL_SERVICE_NAME, OTEL_RESOURCE_ATTRIBUTES and OTEL_TRACES_SAMPLER. Without an endpoint,
+// or with OTEL_SDK_DISABLED=true, the spans are no-ops. It returns the function flushing the spans.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") + os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" || os.Getenv("OTEL_SDK_DISABLED") == "true" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the variables of the environment take precedence over the default service name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(tracerName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// headerCarrier for the request headers of a fiber.Ctx as a propagation.TextMapCarrier
+type headerCarrier struct {
+	c *fiber.Ctx
+}
+
+// Get() for a header, copied, the span context may outlive the request
+func (h headerCarrier) Get(key string) string {
+	return utils.CopyString(h.c.Get(key))
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h.c.Request().Header.Set(key, value)
+}
+
+func (h headerCarrier) Keys() []string {
+	var keys []string
+	h.c.Request().Header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// Tracing func for start a span per request, continuing the trace of the traceparent header.
+// requestContext() gives it to the repository, whose operations are its children.
+func Tracing() func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier{c})
+		method := utils.CopyString(c.Method())
+		_, span := otel.Tracer(tracerName).Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPMethodKey.String(method)))
+		defer span.End()
+		c.Locals("span", span)
+
+		err := c.Next()
+		// the route is known once the request is routed
+		route := c.Route().Path
+		span.SetName(method + " " + route)
+		status := c.Response().StatusCode()
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			status = fiberErr.Code
+		}
+		span.SetAttributes(semconv.HTTPRouteKey.String(route), semconv.HTTPStatusCodeKey.Int(status))
+		if err != nil {
+			span.RecordError(err)
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		return err
+	}
+}
+
+// PrettyJSON func for indent JSON responses with two spaces when the request has pretty=true, or for
+// every request when PRETTY_JSON=true outside production. Responses are compact otherwise.
+func PrettyJSON() func(*fiber.Ctx) error {
+	always := os.Getenv("PRETTY_JSON") == "true" && !IsProduction()
+
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+		if !always && c.Query("pretty") != "true" {
+			return nil
+		}
+		// a stream, such as the JSON Lines export, is not read into memory
+		if c.Response().IsBodyStream() || !isJSONMediaType(c.Response().Header.ContentType()) {
+			return nil
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, c.Response().Body(), "", "  "); err != nil {
+			// not a JSON body after all, it is sent as it is
+			return nil
+		}
+		c.Response().SetBody(indented.Bytes())
+		return nil
+	}
 }
 
-// getTravel() for get a Travel
-func (a *appService) getTravel(c *fiber.Ctx) error {
-	id := c.Params("id")
-	if id == "" {
-		return response(nil, http.StatusUnprocessableEntity, errors.New("id is not defined"), c)
+// isJSONMediaType() for whether a Content-Type is application/json or a +json type, such as the v2 one
+func isJSONMediaType(contentType []byte) bool {
+	mediaType := string(contentType)
+	if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+		mediaType = mediaType[:i]
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-
-	travel, err := a.Repository.findOne(ctx, id)
-	return response(travel, http.StatusOK, err, c)
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == fiber.MIMEApplicationJSON || strings.HasSuffix(mediaType, "+json")
 }
 
-// getTravel() for create a Travel
-func (a *appService) createTravel(c *fiber.Ctx) error {
-	now := time.Now().Unix()
+// filterParams for the query params of travelFilter()
+var filterParams = []string{"done", "q", "tag", "from", "to"}
 
-	// Get claims from JWT.
-	claims, err := ExtractTokenMetadata(c)
-	if err != nil {
-		// Return status 500 and JWT parse error.
-		return response(nil, fiber.StatusInternalServerError, err,c)
-	}
+// countParams for the query params of the endpoints counting the filtered travels
+var countParams = append([]string{"includeArchived"}, filterParams...)
 
-	// Set expiration time from JWT data of current product.
-	expires := claims.Expires
+// listParams for the query params of the travel list
+var listParams = append([]string{"withPhoto", "withTotal", "sort", "limit", "offset"}, countParams...)
 
-	// Checking, if now time greater than expiration from JWT.
-	if now > expires {
-		// Return status 401 and unauthorized error message.
-		msg := "unauthorized, check expiration time of your token"
-		return response(nil, fiber.StatusUnauthorized, errors.New(msg),c)
+// StrictQuery func for reject a request with a query param which is not allowed, with 422 listing them,
+// while STRICT_QUERY_PARAMS=true. pretty is allowed everywhere.
+func StrictQuery(allowed ...string) func(*fiber.Ctx) error {
+	known := map[string]bool{"pretty": true}
+	for _, param := range allowed {
+		known[param] = true
 	}
 
-	var travel Travel
-	if err := c.BodyParser(&travel); err != nil {
-		return response(travel, http.StatusUnprocessableEntity, err, c)
+	return func(c *fiber.Ctx) error {
+		if os.Getenv("STRICT_QUERY_PARAMS") != "true" {
+			return c.Next()
+		}
+		var errs ValidationError
+		c.Context().QueryArgs().VisitAll(func(key, _ []byte) {
+			if param := string(key); !known[param] {
+				errs = append(errs, newFieldError(param, "unknown"))
+			}
+		})
+		if len(errs) > 0 {
+			return response(nil, http.StatusUnprocessableEntity, errs, c)
+		}
+		return c.Next()
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Second)
-	defer cancel()
-
-	err = a.Repository.insertOne(ctx, &travel)
-	return response(travel, http.StatusOK, err, c)
 }
 
-// updateTravel() for update a Travel
-func (a *appService) updateTravel(c *fiber.Ctx) error {
-	now := time.Now().Unix()
+// requestLogOutput for where RequestLogger writes, tests replace it
+var requestLogOutput io.Writer = os.Stderr
 
-	// Get claims from JWT.
-	claims, err := ExtractTokenMetadata(c)
-	if err != nil {
-		// Return status 500 and JWT parse error.
-		return response(nil, fiber.StatusInternalServerError, err,c)
+// RequestLogger func for log requests, the LOG_REDACT_HEADERS values are masked.
+// A ${header:<name>} tag in LOG_FORMAT for a redacted header writes the masked value instead.
+func RequestLogger() func(*fiber.Ctx) error {
+	redacted := redactedHeaders()
+	format := os.Getenv("LOG_FORMAT")
+	for _, header := range redacted {
+		tag := regexp.MustCompile(`(?i)\$\{header:` + regexp.QuoteMeta(header) + `\}`)
+		format = tag.ReplaceAllLiteralString(format, "${locals:redacted-"+header+"}")
 	}
-
-	// Set expiration time from JWT data of current product.
-	expires := claims.Expires
-
-	// Checking, if now time greater than expiration from JWT.
-	if now > expires {
-		// Return status 401 and unauthorized error message.
-		msg := "unauthorized, check expiration time of your token"
-		return response(nil, fiber.StatusUnauthorized, errors.New(msg),c)
+	config := logger.Config{Format: format, Output: requestLogOutput}
+	rate, _ := logSampleRate()
+	if rate < 1 {
+		// the line of a request left out of the sample starts with logSkipMarker, sampledWriter drops it
+		if config.Format == "" {
+			config.Format = logger.ConfigDefault.Format
+		}
+		config.Format = "${locals:log-sample}" + config.Format
+		config.Output = sampledWriter{requestLogOutput}
 	}
+	requestLogger := logger.New(config)
 
-	id := c.Params("id")
-	log.Println(id)
-	if id == "" {
-		return response(nil, http.StatusUnprocessableEntity, errors.New("id is not defined"), c)
-	}
-	var travel Travel
-	if err := c.BodyParser(&travel); err != nil {
-		return response(travel, http.StatusUnprocessableEntity, err, c)
+	return func(c *fiber.Ctx) error {
+		for _, header := range redacted {
+			if value := c.Get(header); value != "" {
+				c.Locals("redacted-"+header, redactHeader(value))
+			}
+		}
+		if rate < 1 {
+			c.Locals("log-sample", logSample{c: c, rate: rate})
+		}
+		return requestLogger(c)
 	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
+// logSkipMarker for the start of a log line which is not in the sample
+const logSkipMarker = "\x00log-skip\x00"
 
-	err = a.Repository.updateOne(ctx, id, &travel)
-	return response(nil, http.StatusNoContent, err, c)
+// logSample for the sampling decision of a request. The logger formats it once the response is
+// written, so a server error is always logged whatever the rate.
+type logSample struct {
+	c    *fiber.Ctx
+	rate float64
 }
 
-// deleteTravel() for delete a travel
-func (a *appService) deleteTravel(c *fiber.Ctx) error {
-	now := time.Now().Unix()
-
-	// Get claims from JWT.
-	claims, err := ExtractTokenMetadata(c)
-	if err != nil {
-		// Return status 500 and JWT parse error.
-		return response(nil, fiber.StatusInternalServerError, err,c)
+// String() for logSkipMarker when the request is left out of the sample, empty otherwise
+func (s logSample) String() string {
+	if s.c.Response().StatusCode() >= fiber.StatusInternalServerError || rand.Float64() < s.rate {
+		return ""
 	}
+	return logSkipMarker
+}
 
-	// Set expiration time from JWT data of current product.
-	expires := claims.Expires
+// sampledWriter for a log output which drops the lines left out of the sample
+type sampledWriter struct {
+	io.Writer
+}
 
-	// Checking, if now time greater than expiration from JWT.
-	if now > expires {
-		// Return status 401 and unauthorized error message.
-		msg := "unauthorized, check expiration time of your token"
-		return response(nil, fiber.StatusUnauthorized, errors.New(msg),c)
+func (w sampledWriter) Write(p []byte) (int, error) {
+	if bytes.HasPrefix(p, []byte(logSkipMarker)) {
+		return len(p), nil
 	}
+	return w.Writer.Write(p)
+}
 
-	id := c.Params("id")
-	log.Println(id)
-	if id == "" {
-		return response(nil, http.StatusUnprocessableEntity, errors.New("id is not defined"), c)
+// logSampleRate() for the fraction of the requests below 500 which are logged, LOG_SAMPLE_RATE or all of them
+func logSampleRate() (float64, error) {
+	value := os.Getenv("LOG_SAMPLE_RATE")
+	if value == "" {
+		return 1, nil
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-
-	err = a.Repository.deleteOne(ctx, id)
-	return response(nil, http.StatusNoContent, err, c)
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("invalid LOG_SAMPLE_RATE %q: must be a number from 0 to 1", value)
+	}
+	return rate, nil
 }
 
-// response to route
-func response(data interface{}, httpStatus int, err error, c *fiber.Ctx) error {
-	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(map[string]string{
-			"error": err.Error(),
-		})
-	} else {
-		if data != nil {
-			return c.Status(httpStatus).JSON(data)
-		} else {
-			c.Status(httpStatus)
-			return nil
+// redactedHeaders() for the header names which never reach the log, Authorization by default
+func redactedHeaders() []string {
+	value := os.Getenv("LOG_REDACT_HEADERS")
+	if value == "" {
+		value = fiber.HeaderAuthorization
+	}
+	var headers []string
+	for _, header := range strings.Split(value, ",") {
+		if header = strings.ToLower(strings.TrimSpace(header)); header != "" {
+			headers = append(headers, header)
 		}
 	}
+	return headers
 }
 
-// Routes for endpoint to access handler
-func Routes(app *fiber.App, service Service) {
-	api := app.Group("/api/v1")
-
-	api.Get("/health", func(c *fiber.Ctx) error {
-		return c.Status(http.StatusOK).
-			JSON(map[string]interface{}{
-				"health": "ok",
-				"status": http.StatusOK,
-			})
-	})
+// redactHeader() for mask a header value, an auth scheme such as Bearer is kept
+func redactHeader(value string) string {
+	if parts := strings.SplitN(value, " ", 2); len(parts) == 2 {
+		return parts[0] + " ***"
+	}
+	return "***"
+}
 
-	// public endpoint
-	api.Get("/token/new", GetNewAccessToken)
-	api.Get("/travels", service.getTravels)
-	api.Get("/travels/:id", service.getTravel)
+// TenantResolver func for resolve the tenant of a request from the X-Tenant-ID header.
+// Missing or unknown tenants are rejected while TENANT_IDS is set.
+func TenantResolver() func(*fiber.Ctx) error {
+	allowed := map[string]bool{}
+	for _, tenant := range tenants() {
+		allowed[tenant] = true
+	}
 
-	// private endpoint
-	api.Post("/travels", JWTProtected(), service.createTravel)
-	api.Put("/travels/:id", JWTProtected(), service.updateTravel)
-	api.Delete("/travels/:id", JWTProtected(), service.deleteTravel)
+	return func(c *fiber.Ctx) error {
+		if len(allowed) == 0 {
+			return c.Next()
+		}
+		tenant := c.Get("X-Tenant-ID")
+		if tenant == "" {
+			return response(nil, http.StatusBadRequest, errors.New("X-Tenant-ID is not defined"), c)
+		}
+		if !allowed[tenant] {
+			return response(nil, http.StatusBadRequest, errors.New("X-Tenant-ID is not a known tenant"), c)
+		}
+		c.Locals("tenant", utils.CopyString(tenant))
+		return c.Next()
+	}
 }
 
 // JWTProtected func for specify routes group with JWT authentication.
+// Other services may send one of SERVICE_API_KEYS in X-API-Key instead of a JWT.
 // See: https://github.com/gofiber/jwt
 func JWTProtected() func(*fiber.Ctx) error {
 	// Create config for JWT authentication middleware.
@@ -391,8 +5543,94 @@ func JWTProtected() func(*fiber.Ctx) error {
 		ContextKey:   "jwt", // used in private routes
 		ErrorHandler: jwtError,
 	}
+	// the Authorization header first, the cookie of browser clients without one
+	if name := os.Getenv("AUTH_COOKIE_NAME"); name != "" {
+		config.TokenLookup = "header:" + fiber.HeaderAuthorization + ",cookie:" + name
+	}
+	keys := serviceAPIKeys()
+	jwtHandler := jwtMiddleware.New(config)
+
+	return func(c *fiber.Ctx) error {
+		key := c.Get("X-API-Key")
+		if key == "" {
+			return jwtHandler(c)
+		}
+		if !validAPIKey(keys, key) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": true,
+				"msg":   "invalid API key",
+			})
+		}
+		// a synthetic identity, so actor() and the audit log name the caller
+		c.Locals("jwt", &jwt.Token{
+			Claims: jwt.MapClaims{"sub": serviceActor, "role": roleAdmin},
+			Valid:  true,
+		})
+		c.Locals("service", true)
+		return c.Next()
+	}
+}
+
+// serviceActor for the identity of the callers authenticated by an API key
+const serviceActor = "service"
+
+// roles of the role claim, /token/new only hands out roleUser
+const (
+	roleUser  = "user"
+	roleAdmin = "admin"
+)
+
+// AdminOnly func for restrict routes to callers with the admin role, API-key callers and tokens
+// signed with "role": "admin". It must follow JWTProtected.
+func AdminOnly() func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if role(c) != roleAdmin {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": true,
+				"msg":   "forbidden, the admin role is required",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// role() for the role claim of the JWT of a request, empty for a token without one
+func role(c *fiber.Ctx) string {
+	if token, ok := c.Locals("jwt").(*jwt.Token); ok {
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			role, _ := claims["role"].(string)
+			return role
+		}
+	}
+	return ""
+}
+
+// serviceAPIKeys() for the API keys accepted in X-API-Key, from the comma separated SERVICE_API_KEYS
+func serviceAPIKeys() [][]byte {
+	var keys [][]byte
+	for _, key := range strings.Split(os.Getenv("SERVICE_API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, []byte(key))
+		}
+	}
+	return keys
+}
+
+// validAPIKey() for whether key is one of keys, compared in constant time
+func validAPIKey(keys [][]byte, key string) bool {
+	valid := false
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare(k, []byte(key)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
 
-	return jwtMiddleware.New(config)
+// isService() for whether the request was authenticated by an API key
+func isService(c *fiber.Ctx) bool {
+	service, _ := c.Locals("service").(bool)
+	return service
 }
 
 func jwtError(c *fiber.Ctx, err error) error {
@@ -446,9 +5684,53 @@ func extractToken(c *fiber.Ctx) string {
 		return onlyToken[1]
 	}
 
+	// Browser clients send it in the AUTH_COOKIE_NAME cookie instead.
+	if name := os.Getenv("AUTH_COOKIE_NAME"); name != "" && bearToken == "" {
+		return c.Cookies(name)
+	}
+
 	return ""
 }
 
+// authCookieSameSite func for the SameSite of the auth cookie, AUTH_COOKIE_SAMESITE lax or strict, lax
+// by default. None is refused: browsers would send the cookie on cross-site requests, and there is no
+// CSRF check to tell them from the client's own.
+func authCookieSameSite() (string, error) {
+	switch sameSite := strings.ToLower(os.Getenv("AUTH_COOKIE_SAMESITE")); sameSite {
+	case "":
+		return "lax", nil
+	case "lax", "strict":
+		return sameSite, nil
+	case "none":
+		return "", errors.New("invalid AUTH_COOKIE_SAMESITE: none would allow CSRF with the auth cookie")
+	default:
+		return "", fmt.Errorf("invalid AUTH_COOKIE_SAMESITE %q: must be lax or strict", sameSite)
+	}
+}
+
+// authCookie func to describe the cookie holding an access token, configured by AUTH_COOKIE_NAME,
+// AUTH_COOKIE_SAMESITE and AUTH_COOKIE_SECURE.
+func authCookie(token string, ttl time.Duration) *fiber.Cookie {
+	// run() refused an invalid AUTH_COOKIE_SAMESITE
+	sameSite, err := authCookieSameSite()
+	if err != nil {
+		sameSite = "lax"
+	}
+	secure := IsProduction()
+	if value, err := strconv.ParseBool(os.Getenv("AUTH_COOKIE_SECURE")); err == nil {
+		secure = value
+	}
+	return &fiber.Cookie{
+		Name:     os.Getenv("AUTH_COOKIE_NAME"),
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(ttl),
+		HTTPOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
+	}
+}
+
 func verifyToken(c *fiber.Ctx) (*jwt.Token, error) {
 	tokenString := extractToken(c)
 
@@ -469,14 +5751,20 @@ func GenerateNewAccessToken() (string, error) {
 	// Set secret key from .env file.
 	secret := os.Getenv("JWT_SECRET_KEY")
 
-	// Set expires minutes count for secret key from .env file.
-	minutesCount, _ := strconv.Atoi(os.Getenv("JWT_SECRET_KEY_EXPIRE_MINUTES_COUNT"))
+	// Set expires duration for secret key from .env file.
+	ttl, err := tokenTTL()
+	if err != nil {
+		return "", err
+	}
 
 	// Create a new claims.
 	claims := jwt.MapClaims{}
 
 	// Set public claims:
-	claims["exp"] = time.Now().Add(time.Minute * time.Duration(minutesCount)).Unix()
+	claims["exp"] = time.Now().Add(ttl).Unix()
+	claims["role"] = roleUser
+	// there are no accounts, each token gets a user of its own, which its locks and audit records name
+	claims["sub"] = primitive.NewObjectID().Hex()
 
 	// Create a new JWT access token with claims.
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -491,15 +5779,117 @@ func GenerateNewAccessToken() (string, error) {
 	return t, nil
 }
 
+// tokenTTL func for the access token lifetime, JWT_ACCESS_TTL (e.g. 15m, 2h) takes precedence over
+// JWT_SECRET_KEY_EXPIRE_MINUTES_COUNT.
+func tokenTTL() (time.Duration, error) {
+	if value := os.Getenv("JWT_ACCESS_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid JWT_ACCESS_TTL: %w", err)
+		}
+		if ttl <= 0 {
+			return 0, errors.New("invalid JWT_ACCESS_TTL: must be positive")
+		}
+		return ttl, nil
+	}
+
+	minutesCount, _ := strconv.Atoi(os.Getenv("JWT_SECRET_KEY_EXPIRE_MINUTES_COUNT"))
+	return time.Minute * time.Duration(minutesCount), nil
+}
+
+// GetTravelSchema method for describe the fields of a Travel.
+func GetTravelSchema(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"fields": travelSchema(),
+	})
+}
+
+// configKeys for the environment variables reported by GetConfig
+var configKeys = []string{
+	"APP_ENVIRONMENT", "PORT", "FALLBACK_PORT",
+	"SERVER_READ_TIMEOUT", "SERVER_CONCURRENCY", "SERVER_READ_BUFFER_SIZE", "SERVER_DISABLE_KEEPALIVE",
+	"TRUSTED_PROXIES", "PROXY_HEADER", "REQUEST_ID_HEADER", "CORS_MAX_AGE_SECONDS",
+	"JWT_SECRET_KEY", "JWT_SECRET_KEY_EXPIRE_MINUTES_COUNT", "JWT_ACCESS_TTL", "SERVICE_API_KEYS",
+	"AUTH_COOKIE_NAME", "AUTH_COOKIE_SAMESITE", "AUTH_COOKIE_SECURE",
+	"DATABASE_URI", "DB_TLS_CA_FILE", "DB_TLS_INSECURE", "DB_POOL_WARMUP", "DB_HEALTHCHECK_INTERVAL", "DATABASE_NAME", "TRAVEL_COLLECTION", "TENANT_IDS",
+	"REQUIRE_EXISTING_COLLECTION", "SLOW_QUERY_THRESHOLD_MS", "REQUEST_RETRY_ON_FAILOVER", "READY_CHECK_INDEXES",
+	"CACHE_ENABLED", "CACHE_TTL_SECONDS", "CACHE_SIZE", "SERVE_STALE_ON_ERROR",
+	"SCHEMA_ENFORCEMENT", "MAX_NAME_LENGTH",
+	"DEFAULT_SORT", "MAX_PAGE_OFFSET", "STATS_TZ", "DISPLAY_TZ", "LOCK_TTL_SECONDS", "MAX_TAGS_PER_TRAVEL", "DEFAULT_PHOTO_URL", "ALLOWED_PHOTO_HOSTS",
+	"MAINTENANCE_MODE", "MAINTENANCE_RETRY_AFTER", "EXPORT_RATE_LIMIT", "MAX_REPLICA_LAG_SECONDS", "REPLICA_LAG_CHECK_SECONDS",
+	"LOG_FORMAT", "LOG_REDACT_HEADERS", "LOG_SAMPLE_RATE", "PRETTY_JSON", "STRICT_QUERY_PARAMS", "DEFAULT_LOCALE",
+	"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_SERVICE_NAME",
+}
+
+// secretConfigKeys for the environment variables whose value is never reported
+var secretConfigKeys = map[string]bool{
+	"JWT_SECRET_KEY":   true,
+	"SERVICE_API_KEYS": true,
+}
+
+// secretURIParams for the parts of the names of the connection string options which carry credentials
+var secretURIParams = []string{"password", "secret", "token", "authsource", "authmechanismproperties"}
+
+// configValue() for the value of an environment variable, with secrets and URI credentials masked:
+// the user info of DATABASE_URI is dropped and its credential options are masked
+func configValue(key string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return ""
+	}
+	if secretConfigKeys[key] {
+		return "[REDACTED]"
+	}
+	if key == "DATABASE_URI" {
+		u, err := url.Parse(value)
+		if err != nil {
+			return "[REDACTED]"
+		}
+		u.User = nil
+		query := u.Query()
+		for name := range query {
+			for _, secret := range secretURIParams {
+				if strings.Contains(strings.ToLower(name), secret) {
+					query.Set(name, "xxxxx")
+				}
+			}
+		}
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return value
+}
+
+// GetConfig method for report the configuration the server is running with, secrets redacted.
+func GetConfig(c *fiber.Ctx) error {
+	config := make(map[string]string, len(configKeys))
+	for _, key := range configKeys {
+		config[key] = configValue(key)
+	}
+	return c.JSON(fiber.Map{
+		"config": config,
+	})
+}
+
 // GetNewAccessToken method for create a new access token.
 // @Description Create a new access token.
 // @Summary create a new access token
 // @Tags Token
 // @Accept json
 // @Produce json
+// @Param cookie query bool false "also set the token in the AUTH_COOKIE_NAME cookie"
 // @Success 200 {string} status "ok"
 // @Router /v1/token/new [get]
 func GetNewAccessToken(c *fiber.Ctx) error {
+	// The token goes in a cookie as well with ?cookie=true.
+	withCookie := c.Query("cookie") == "true"
+	if withCookie && os.Getenv("AUTH_COOKIE_NAME") == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": true,
+			"msg":   "cookie tokens are disabled, AUTH_COOKIE_NAME is not set",
+		})
+	}
+
 	// Generate a new Access token.
 	token, err := GenerateNewAccessToken()
 	if err != nil {
@@ -510,6 +5900,12 @@ func GetNewAccessToken(c *fiber.Ctx) error {
 		})
 	}
 
+	if withCookie {
+		// tokenTTL has just succeeded for the token
+		ttl, _ := tokenTTL()
+		c.Cookie(authCookie(token, ttl))
+	}
+
 	return c.JSON(fiber.Map{
 		"error":        false,
 		"msg":          nil,
@@ -517,36 +5913,207 @@ func GetNewAccessToken(c *fiber.Ctx) error {
 	})
 }
 
+// serverConfig() for the fiber config from env, unset tuning options keep the fiber defaults
+func serverConfig() fiber.Config {
+	readTimeoutSecondsCount, _ := strconv.Atoi(os.Getenv("SERVER_READ_TIMEOUT"))
+	concurrency, _ := strconv.Atoi(os.Getenv("SERVER_CONCURRENCY"))
+	readBufferSize, _ := strconv.Atoi(os.Getenv("SERVER_READ_BUFFER_SIZE"))
+	trustedProxies := envList("TRUSTED_PROXIES")
+
+	return fiber.Config{
+		ReadTimeout:      time.Second * time.Duration(readTimeoutSecondsCount),
+		Concurrency:      concurrency,
+		ReadBufferSize:   readBufferSize,
+		DisableKeepalive: os.Getenv("SERVER_DISABLE_KEEPALIVE") == "true",
+		// c.IP() is read from the proxy header only for requests coming from a trusted proxy
+		EnableTrustedProxyCheck: len(trustedProxies) > 0,
+		TrustedProxies:          trustedProxies,
+		ProxyHeader:             proxyHeader(trustedProxies),
+	}
+}
+
+// proxyHeader() for the client IP header set by the trusted proxies, X-Forwarded-For unless PROXY_HEADER is set
+func proxyHeader(trustedProxies []string) string {
+	if len(trustedProxies) == 0 {
+		return ""
+	}
+	if header := os.Getenv("PROXY_HEADER"); header != "" {
+		return header
+	}
+	return fiber.HeaderXForwardedFor
+}
+
+// requestIDHeader() for the correlation id header, X-Request-ID unless REQUEST_ID_HEADER is set
+func requestIDHeader() string {
+	if header := os.Getenv("REQUEST_ID_HEADER"); header != "" {
+		return header
+	}
+	return fiber.HeaderXRequestID
+}
+
+// corsMaxAge() for how long browsers may cache a preflight result, in seconds
+func corsMaxAge() int {
+	maxAge, err := strconv.Atoi(os.Getenv("CORS_MAX_AGE_SECONDS"))
+	if err != nil || maxAge < 0 {
+		return 600
+	}
+	return maxAge
+}
+
+// listen() for the listener of the server on PORT. In production it fails when PORT is unset or taken.
+// Elsewhere an unset PORT falls back to FALLBACK_PORT (8080 by default), and a taken port to a free one.
+func listen() (net.Listener, error) {
+	port := os.Getenv("PORT")
+	if IsProduction() {
+		if port == "" {
+			return nil, errors.New("PORT is not defined")
+		}
+		return net.Listen("tcp", ":"+port)
+	}
+
+	if port == "" {
+		if port = os.Getenv("FALLBACK_PORT"); port == "" {
+			port = "8080"
+		}
+		log.Printf("PORT is not defined, using %s", port)
+	}
+	ln, err := net.Listen("tcp", ":"+port)
+	if err == nil {
+		return ln, nil
+	}
+	log.Printf("cannot listen on port %s: %v, picking a free port", port, err)
+	if ln, err = net.Listen("tcp", ":0"); err != nil {
+		return nil, err
+	}
+	log.Printf("listening on %s", ln.Addr())
+	return ln, nil
+}
+
 // run() for initialize fiber app
 func run() error {
-	port := os.Getenv("PORT")
 	dbURI := os.Getenv("DATABASE_URI")
 
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Println("tracing:", err)
+		}
+	}()
+
+	if _, err := tokenTTL(); err != nil {
+		return err
+	}
+	if _, err := defaultSort(); err != nil {
+		return err
+	}
+	if _, err := logSampleRate(); err != nil {
+		return err
+	}
+	if _, err := authCookieSameSite(); err != nil {
+		return err
+	}
+	if displayLocation, err = loadDisplayLocation(); err != nil {
+		return err
+	}
+	if statsLocation, err = loadStatsLocation(); err != nil {
+		return err
+	}
+	interval, err := dbHealthcheckInterval()
+	if err != nil {
+		return err
+	}
+	ln, err := listen()
+	if err != nil {
+		return err
+	}
+
 	// conn -> repo
 	r, err := NewRepo(dbURI)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	defer r.Close()
 
+	if os.Getenv("REQUEST_RETRY_ON_FAILOVER") == "true" {
+		r = NewFailoverRepo(r)
+		log.Println("retry on primary failover enabled")
+	}
+	if os.Getenv("CACHE_ENABLED") == "true" {
+		ttlSeconds, err := strconv.Atoi(os.Getenv("CACHE_TTL_SECONDS"))
+		if err != nil || ttlSeconds <= 0 {
+			ttlSeconds = 30
+		}
+		size, err := strconv.Atoi(os.Getenv("CACHE_SIZE"))
+		if err != nil || size <= 0 {
+			size = 1000
+		}
+		serveStale := os.Getenv("SERVE_STALE_ON_ERROR") == "true"
+		r = NewCachedRepo(r, time.Second*time.Duration(ttlSeconds), size, serveStale)
+		log.Printf("travel cache enabled, ttl %ds, size %d, serve stale %t", ttlSeconds, size, serveStale)
+	}
+
 	// repo -> service
 	service := NewService(r)
 
-	readTimeoutSecondsCount, _ := strconv.Atoi(os.Getenv("SERVER_READ_TIMEOUT"))
 	// fiber initialize
-	app := fiber.New(fiber.Config{
-		ReadTimeout: time.Second * time.Duration(readTimeoutSecondsCount),
-	})
+	app := fiber.New(serverConfig())
 
+	app.Use(Tracing())
+	// production logs requests only when they are sampled
+	if !IsProduction() || os.Getenv("LOG_SAMPLE_RATE") != "" {
+		app.Use(RequestLogger())
+	}
 	if !IsProduction() {
-		app.Use(logger.New())
-		app.Use(cors.New())
+		app.Use(cors.New(cors.Config{
+			MaxAge: corsMaxAge(),
+		}))
+	}
+	app.Use(requestid.New(requestid.Config{
+		Header:     requestIDHeader(),
+		ContextKey: "requestid",
+	}))
+	app.Use(PrettyJSON())
+	app.Use(MaintenanceMode())
+	if gate := NewReplicaLagGate(r); gate != nil {
+		gate.Start()
+		defer gate.Stop()
+		app.Use(gate.Handler())
+	}
+
+	var monitor *DBMonitor
+	if interval > 0 {
+		monitor = NewDBMonitor(r, interval)
+		monitor.Start()
+		defer monitor.Stop()
+		log.Printf("database health monitor enabled, interval %s", interval)
 	}
 
 	// service -> routes
-	Routes(app, service)
-	return app.Listen(fmt.Sprintf(":%s", port))
+	Routes(app, service, monitor)
+
+	// SIGINT and SIGTERM shut the server down gracefully, so the deferred cleanup above runs
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case sig := <-quit:
+			log.Printf("received %s, shutting down", sig)
+			if err := app.Shutdown(); err != nil {
+				log.Println("shutdown:", err)
+			}
+		case <-stopped:
+		}
+	}()
+	return app.Listener(ln)
 }
 
 // yeah!! GO
@@ -557,4 +6124,4 @@ func main() {
 	if err := run(); err != nil {
 		log.Fatal(err)
 	}
-}
\ No newline at end of file
+}